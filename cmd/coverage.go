@@ -0,0 +1,40 @@
+// Copyright (c) 2022 CopilotIQ Inc.  All rights reserved
+
+package main
+
+import (
+	. "github.com/CopilotIQ/opa-tests/testing/internals"
+	"os"
+	"path/filepath"
+)
+
+const (
+	CoverageJson = "coverage.json"
+	CoverageLcov = "lcov.info"
+	CoverageHtml = "coverage.html"
+)
+
+// writeCoverage saves the coverage `report` in the `dir` directory, in all
+// the supported formats.
+func writeCoverage(dir string, report *CoverageReport) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	writers := map[string]func(*os.File) error{
+		CoverageJson: func(f *os.File) error { return encodeJson(f, report) },
+		CoverageLcov: func(f *os.File) error { return report.WriteLCOV(f) },
+		CoverageHtml: func(f *os.File) error { return report.WriteHTML(f) },
+	}
+	for name, write := range writers {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		err = write(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
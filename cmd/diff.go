@@ -0,0 +1,157 @@
+// Copyright (c) 2022 CopilotIQ Inc.  All rights reserved
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	. "github.com/CopilotIQ/opa-tests/testing/internals"
+	slf4go "github.com/massenz/slf4go/logging"
+	"os"
+	"strings"
+)
+
+// diff evaluates the same inputs against two versions of the policies, and lists all
+// those whose decision changed; it returns the process exit code.
+func diff(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	from := flags.String("from", "", "The policies to compare from: a bundle, a directory or a git ref")
+	to := flags.String("to", "",
+		"The policies to compare to: a bundle, a directory or a git ref (default: the -src directory)")
+	manifest := flags.String("manifest", Manifest, "Path to the manifest file")
+	src := flags.String("src", Sources, "Path to policies (Rego), also used for the git refs")
+	corpus := flags.String("corpus", "",
+		"Path to a JSONL file of additional inputs, one `{\"path\": ..., \"input\": ...}` per line")
+	out := flags.String("out", "", "Path to the (JSON) report of the changed decisions")
+	workers := flags.Uint("workers", 0, "Number of parallel threads to run")
+	exitCode := flags.Bool("exit-code", false, "Exits with 1 if any decision changed")
+	debug := flags.Bool("v", false, "Enable verbose logging")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s diff -from REF [-to REF] [-v] [-manifest MANIFEST] [-src SRC] "+
+			"[-corpus JSONL] [-out REPORT] [-exit-code] [TESTS]\n\n", ProgName)
+		flags.PrintDefaults()
+		//goland:noinspection GoPrintFunctions
+		fmt.Printf("\nEvaluates the tests in the TESTS folder (default \"%s\"), and the corpus, against "+
+			"two versions of the policies, and lists all the inputs whose decision changed\n", Tests)
+	}
+	_ = flags.Parse(args)
+	if *debug {
+		Log.Level = slf4go.DEBUG
+	}
+	if *from == "" {
+		flags.Usage()
+		return 2
+	}
+
+	testsDir := flags.Arg(0)
+	if testsDir == "" {
+		testsDir = Tests
+	}
+	tests, err := Generate(testsDir)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	decisions := DecisionsFromTests(tests)
+	if *corpus != "" {
+		entries, err := ReadCorpus(*corpus)
+		if err != nil {
+			Log.Error("cannot read the corpus: %v", err)
+			return 1
+		}
+		decisions = append(decisions, entries...)
+	}
+
+	var addresses [2]string
+	for i, spec := range []string{*from, *to} {
+		bundle, cleanup, err := resolveBundle(spec, *manifest, *src)
+		if err != nil {
+			Log.Error("cannot create the bundle for %s: %v", describeSpec(spec, *src), err)
+			return 1
+		}
+		defer cleanup()
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultOpaContainerStartTimeout)
+		server, err := NewOpaContainer(ctx, bundle)
+		cancel()
+		if err != nil {
+			Log.Error("cannot start the OPA server: %v", err)
+			return 1
+		}
+		defer func() {
+			if err := server.Container.Terminate(context.Background()); err != nil {
+				Log.Error("failed to stop OPA container: %v", err)
+			}
+		}()
+		Log.Info("OPA Server for %s started", describeSpec(spec, *src))
+		addresses[i] = server.Address
+	}
+
+	changes := DiffDecisions(addresses[0], addresses[1], decisions, *workers)
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	fmt.Printf("%d of %d decisions changed from %s to %s\n", len(changes), len(decisions),
+		describeSpec(*from, *src), describeSpec(*to, *src))
+	if *out != "" {
+		if err := writeJson(*out, changes); err != nil {
+			Log.Error("cannot save the report: %v", err)
+			return 1
+		}
+		Log.Info("Changed decisions saved to %s", *out)
+	}
+	if *exitCode && len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// resolveBundle returns the path of the bundle for the `spec`, which is either an existing
+// bundle, a directory with the policies, or a git ref (in which case the policies are those
+// in the `src` directory, at that ref); an empty `spec` is the `src` directory.
+//
+// The returned function removes all the temporary files.
+func resolveBundle(spec string, manifest string, src string) (string, func(), error) {
+	var noop = func() {}
+	if spec == "" {
+		spec = src
+	}
+	if info, err := os.Stat(spec); err == nil {
+		if !info.IsDir() {
+			return spec, noop, nil
+		}
+		bundle, err := CreateBundle(manifest, spec)
+		if err != nil {
+			return "", noop, err
+		}
+		return bundle, func() { os.Remove(bundle) }, nil
+	}
+	dir, err := os.MkdirTemp("", "policies-*")
+	if err != nil {
+		return "", noop, err
+	}
+	if err := CheckoutPolicies(spec, src, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", noop, err
+	}
+	bundle, err := CreateBundle(manifest, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", noop, err
+	}
+	return bundle, func() {
+		os.Remove(bundle)
+		os.RemoveAll(dir)
+	}, nil
+}
+
+func describeSpec(spec string, src string) string {
+	if spec == "" {
+		return src
+	}
+	if _, err := os.Stat(spec); err == nil {
+		return spec
+	}
+	return strings.Join([]string{spec, src}, ":")
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2022 CopilotIQ Inc.  All rights reserved
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	. "github.com/CopilotIQ/opa-tests/testing/internals"
+	slf4go "github.com/massenz/slf4go/logging"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// export writes the requests generated from the tests as curl commands, HTTP files or JSONL,
+// so that they can be sent to a local OPA server, or as Rego tests for `opa test`; it
+// returns the process exit code.
+func export(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", CurlFormat,
+		fmt.Sprintf("Format of the requests, one of: %s", strings.Join(ExportFormats, ", ")))
+	url := flags.String("url", DefaultOpaUrl, "URL of the OPA server the requests are sent to")
+	out := flags.String("out", "",
+		"Path to the exported requests (default: stdout), or the directory of the rego tests")
+	run := flags.String("run", "", "Only export the tests whose name (Testcase.test) matches the regular expression")
+	debug := flags.Bool("v", false, "Enable verbose logging")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s export [-v] [-format FORMAT] [-url URL] [-out FILE] [-run REGEX] [TESTS]\n\n",
+			ProgName)
+		flags.PrintDefaults()
+		//goland:noinspection GoPrintFunctions
+		fmt.Printf("\nExports the requests of the tests in the TESTS folder (default \"%s\"), "+
+			"e.g. to send them to `opa run --server`\n", Tests)
+	}
+	_ = flags.Parse(args)
+	if *debug {
+		Log.Level = slf4go.DEBUG
+	}
+
+	testsDir := flags.Arg(0)
+	if testsDir == "" {
+		testsDir = Tests
+	}
+	tests, err := Generate(testsDir)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if *run != "" {
+		var filter Filter
+		if filter.Run, err = regexp.Compile(*run); err != nil {
+			Log.Error("invalid -run expression: %v", err)
+			return 2
+		}
+		tests = filter.Apply(tests)
+	}
+
+	if *format == RegoFormat {
+		if *out == "" {
+			Log.Error("the %s tests must be written to a directory, use -out DIR", RegoFormat)
+			return 2
+		}
+		paths, err := WriteRegoTests(*out, tests)
+		if err != nil {
+			Log.Error("cannot export the Rego tests: %v", err)
+			return 1
+		}
+		Log.Info("%d tests exported to %d Rego modules in %s", len(tests), len(paths), *out)
+		return 0
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		EnsureReportDir(*out)
+		file, err := os.Create(*out)
+		if err != nil {
+			Log.Error("cannot create %s: %v", *out, err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+		if *format == CurlFormat {
+			_ = file.Chmod(0750)
+		}
+	}
+	if err := Export(w, tests, *format, strings.TrimSuffix(*url, UrlSep)); err != nil {
+		Log.Error("cannot export the requests: %v", err)
+		return 1
+	}
+	if *out != "" {
+		Log.Info("%d requests exported to %s", len(tests), *out)
+	}
+	return 0
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2022 CopilotIQ Inc.  All rights reserved
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	"os"
+)
+
+// lint validates all the Testcases in the given files (or directories) and
+// reports all the problems found; it returns the process exit code.
+func lint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s lint [TESTS ...]\n\n", ProgName)
+		//goland:noinspection GoPrintFunctions
+		fmt.Printf("Validates the Testcases in the TESTS files or folders (default \"%s\")\n", Tests)
+	}
+	_ = flags.Parse(args)
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{Tests}
+	}
+	var found int
+	for _, path := range paths {
+		files, err := TestcaseFiles(path)
+		if err != nil {
+			Log.Error("cannot read %s: %v", path, err)
+			return 2
+		}
+		for _, file := range files {
+			problems, err := Lint(file)
+			if err != nil {
+				Log.Error("cannot read %s: %v", file, err)
+				return 2
+			}
+			for _, p := range problems {
+				fmt.Println(p)
+			}
+			found += len(problems)
+		}
+	}
+	if found > 0 {
+		fmt.Fprintf(os.Stderr, "%d problem(s) found\n", found)
+		return 1
+	}
+	return 0
+}
@@ -13,6 +13,7 @@ import (
 	slf4go "github.com/massenz/slf4go/logging"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -35,7 +36,29 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "lint":
+			os.Exit(lint(os.Args[2:]))
+		case "validate":
+			os.Exit(validate(os.Args[2:]))
+		case "mutate":
+			os.Exit(mutate(os.Args[2:]))
+		case "diff":
+			os.Exit(diff(os.Args[2:]))
+		case "replay":
+			os.Exit(replay(os.Args[2:]))
+		case "record":
+			os.Exit(record(os.Args[2:]))
+		case "export":
+			os.Exit(export(os.Args[2:]))
+		}
+	}
+	os.Exit(runSuite())
+}
 
+// runSuite runs all the tests, and returns the process exit code.
+func runSuite() int {
 	manifest := flag.String("manifest", Manifest, "Path to the manifest file")
 	src := flag.String("src", Sources, "Path to policies (Rego)")
 	out := flag.String("out", Out, "Path to test results report")
@@ -46,13 +69,71 @@ func main() {
 	debug := flag.Bool("v", false, "Enable verbose logging")
 	skipTests := flag.Bool("x", false, "Generates the bundle and exits")
 	bundleLoc := flag.String("bundle", DefaultBundleDir, "Directory where to save the bundle")
+	run := flag.String("run", "", "Only run the tests whose name (Testcase.test) matches the regular expression")
+	skip := flag.String("skip", "", "Skip the tests whose name (Testcase.test) matches the regular expression")
+	tags := flag.String("tags", "", "Only run the tests with at least one of the (comma-separated) tags")
+	excludeTags := flag.String("exclude-tags", "", "Skip the tests with any of the (comma-separated) tags")
+	targets := flag.String("target", "",
+		"Only run the tests for the (comma-separated) targets, e.g.: copilotiq/allow")
+	ci := flag.Bool("ci", false, "Fails if any of the tests is marked `only`")
+	coverageDir := flag.String("coverage", "",
+		"Directory where to save the policies coverage reports (JSON, LCOV and HTML)")
+	minCoverage := flag.Float64("min-coverage", 0,
+		"Fails the run if the policies coverage (in percent) is below this threshold")
+	explain := flag.Bool("explain", true,
+		"Adds to the report of each failed test an explanation of how the policies were evaluated")
+	bodiesReport := flag.String("rule-bodies", "",
+		"Path to the report of which rule bodies decided the tests expected to be true")
+	failUncovered := flag.Bool("fail-uncovered", false,
+		"Fails the run if any of the targeted rule bodies never decided a test expected to be true")
+	quiet := flag.Bool("quiet", false, "Only shows the failed tests, and the final counts")
+	verbose := flag.Bool("verbose", false, "Shows each of the tests' results, with their duration")
+	baselinePath := flag.String("baseline", "",
+		"Path to the results of a previous run: only fails the run if there are regressions")
+	regoTests := flag.Bool("rego-tests", true,
+		"Also runs the Rego test modules (*_test.rego) in SRC, reporting their results with the other tests")
+	watch := flag.Bool("watch", false,
+		"Keeps running, and reruns the tests affected by changes to the policies, manifest or Testcases")
+	watchInterval := flag.Duration("watch-interval", 500*time.Millisecond,
+		"How often the files are checked for changes, with -watch")
+	opaLogs := flag.String("opa-logs", "",
+		"Saves the OPA server logs, including its decision logs, to this file, and adds the failed tests' "+
+			"decision logs to the report")
+	reuse := flag.Bool("reuse", false,
+		"Reuses the OPA container across runs (creating it if needed), pushing the bundle's policies to it")
+	containerName := flag.String("container", ReusedContainerName, "Name of the OPA container reused with -reuse")
+	signingKey := flag.String("signing-key", "",
+		"Signs the bundle with this key (the secret for the HS algorithms, the PEM private key otherwise), "+
+			"and has the OPA server verify it")
+	signingAlg := flag.String("signing-alg", DefaultSigningAlg,
+		fmt.Sprintf("Algorithm the bundle is signed with, one of: %s", strings.Join(SigningAlgs, ", ")))
+	signingKeyID := flag.String("signing-key-id", DefaultSigningKeyID, "ID of the key the bundle is signed with")
+	verificationKey := flag.String("verification-key", "",
+		"Path to the PEM public key the bundle signature is verified with (default: the -signing-key secret)")
+	format := flag.String("format", ConsoleFormat, fmt.Sprintf(
+		"Output format of the results, one of: %s (github also adds annotations to the console output, "+
+			"html also saves the report next to -out)",
+		strings.Join(Formats, ", ")))
 
 	flag.Usage = func() {
-		fmt.Printf("Usage: %s [-v] [-manifest MANIFEST] [-src SRC] "+
-			"[-templates TEMPLATES] [-out REPORT] [TESTS]\n\n", ProgName)
+		fmt.Printf("Usage: %s [-v] [-ci] [-manifest MANIFEST] [-src SRC] "+
+			"[-templates TEMPLATES] [-out REPORT] [-run REGEX] [-skip REGEX] [-tags TAGS] "+
+			"[-exclude-tags TAGS] [-target TARGETS] [-coverage DIR] [-min-coverage PCT] "+
+			"[-rule-bodies REPORT] [-fail-uncovered] [-quiet|-verbose] [-format FORMAT] [-baseline REPORT] "+
+			"[-rego-tests=false] [-watch] [-reuse [-container NAME]] [-opa-logs FILE] "+
+			"[-signing-key KEY [-signing-alg ALG] [-signing-key-id ID] [-verification-key KEY]] [TESTS]\n\n", ProgName)
 		flag.PrintDefaults()
 		//goland:noinspection GoPrintFunctions
-		fmt.Printf("\nRuns all the tests in the TESTS folder (default \"%s\")\n", Tests)
+		fmt.Printf("\nRuns all the tests in the TESTS folder or file (default \"%s\")\n", Tests)
+		fmt.Printf("\nCommands:\n"+
+			"  lint\t\tvalidates the Testcases, without running them (see: %[1]s lint -h)\n"+
+			"  validate\tcompiles the policies and verifies the tests' targets (see: %[1]s validate -h)\n"+
+			"  mutate\truns the tests against mutants of the policies (see: %[1]s mutate -h)\n"+
+			"  diff\t\tlists the decisions changed between two versions of the policies (see: %[1]s diff -h)\n"+
+			"  replay\treplays the OPA decision logs against the policies (see: %[1]s replay -h)\n"+
+			"  record\tsaves (or checks) the requests and responses as golden files (see: %[1]s record -h)\n"+
+			"  export\texports the tests' requests as curl commands, HTTP files or JSONL (see: %[1]s export -h)\n",
+			ProgName)
 	}
 	flag.Parse()
 
@@ -67,10 +148,76 @@ func main() {
 		testsDir = Tests
 	}
 
+	if !validFormat(*format) {
+		Log.Fatal(fmt.Errorf("invalid -format %s, must be one of: %s", *format, strings.Join(Formats, ", ")))
+	}
+
+	filter := Filter{
+		Tags:        SplitList(*tags),
+		ExcludeTags: SplitList(*excludeTags),
+		Targets:     SplitList(*targets),
+	}
+	var err error
+	if *run != "" {
+		filter.Run, err = regexp.Compile(*run)
+		if err != nil {
+			Log.Fatal(fmt.Errorf("invalid -run expression: %v", err))
+		}
+	}
+	if *skip != "" {
+		filter.Skip, err = regexp.Compile(*skip)
+		if err != nil {
+			Log.Fatal(fmt.Errorf("invalid -skip expression: %v", err))
+		}
+	}
+	verbosity := Normal
+	if *quiet {
+		verbosity = Quiet
+	} else if *verbose {
+		verbosity = Verbose
+	}
+	console := NewConsoleReporter(os.Stdout, verbosity)
+	if *watch {
+		// The watcher does not bundle the policies, nor save any report
+		if err := checkWatchFlags(); err != nil {
+			Log.Fatal(err)
+		}
+		watcher := suiteWatcher{manifest: *manifest, src: *src, testsDir: testsDir, filter: filter,
+			workers: *workers, regoTests: *regoTests, console: console}
+		return watcher.watch(*watchInterval)
+	}
+
+	// The baseline is loaded first, as it may well be the report this run will overwrite
+	var baseline *TestReport
+	if *baselinePath != "" {
+		baseline, err = LoadReport(*baselinePath)
+		if err != nil {
+			Log.Fatal(fmt.Errorf("cannot load the baseline: %v", err))
+		}
+	}
+
 	m := ReadManifest(*manifest)
 
 	Log.Info("Generating Bundle rev. %s from %s", m.Revision, *src)
-	bundle, err := CreateBundle(*manifest, *src)
+	var signing *BundleSigning
+	if *signingKey != "" {
+		signing, err = NewBundleSigning(*signingKey, *verificationKey, *signingAlg, *signingKeyID)
+		if err != nil {
+			Log.Fatal(err)
+		}
+	}
+	bundle, err := CreateSignedBundle(*manifest, *src, signing)
+	if err != nil {
+		Log.Fatal(err)
+	}
+	if signing != nil {
+		if err := signing.VerifyBundle(bundle); err != nil {
+			_ = os.Remove(bundle)
+			Log.Fatal(err)
+		}
+		Log.Info("Bundle signed with %s (key ID: %s), signature verified", signing.Algorithm, signing.KeyID)
+	}
+	digest, err := BundleSHA256(bundle)
 	if err != nil {
 		Log.Fatal(err)
 	}
@@ -85,23 +232,34 @@ func main() {
 		err := os.Rename(bundle, destination)
 		if err != nil {
 			Log.Error("Could not save bundle %s: %v", destination, err)
-			os.Exit(1)
+			return 1
 		}
-		fmt.Printf("Bundle created: %s\n", destination)
-		return
+		fmt.Printf("Bundle created: %s\nSHA-256: %s\n", destination, digest)
+		return 0
 	}
 	defer os.Remove(bundle)
-	Log.Debug("bundle %s created", bundle)
+	Log.Info("Bundle %s created (SHA-256: %s)", bundle, digest)
 
 	Log.Info("Generating Testcases from: %s", testsDir)
-	tests, err := Generate(testsDir)
+	generated, err := Generate(testsDir)
 	if err != nil {
 		Log.Fatal(fmt.Errorf("cannot read test cases: %s", err))
 	}
+	if focused := Focused(generated); *ci && len(focused) > 0 {
+		Log.Fatal(fmt.Errorf("tests marked `only` are not allowed in CI: %s",
+			strings.Join(focused, ", ")))
+	}
+	tests := filter.Apply(generated)
 	if len(tests) == 0 {
 		Log.Fatal(fmt.Errorf("nothing to do"))
 	}
-	Log.Info("All tests generated")
+	Log.Info("All tests generated, %d selected to run", len(tests))
+
+	policies := checkPolicies(*src, tests)
+	if policies == nil {
+		return 1
+	}
+	Log.Info("Policies compiled, all tests' targets resolved")
 
 	if *templates != "" {
 		Log.Fatal(fmt.Errorf("JSON templates support not implemented yet"))
@@ -112,33 +270,184 @@ func main() {
 	defer file.Close()
 
 	start := time.Now()
-	ctx, cancel := context.WithTimeout(context.Background(), DefaultOpaContainerStartTimeout)
+	startCtx, cancel := context.WithTimeout(context.Background(), DefaultOpaContainerStartTimeout)
 	defer cancel()
-	server, err := NewOpaContainer(ctx, bundle)
+	var reused string
+	if *reuse {
+		reused = *containerName
+	}
+	var opaFlags []string
+	if *opaLogs != "" {
+		opaFlags = DecisionLogsFlags
+	}
+	if signing != nil {
+		if reused != "" {
+			// The policies are pushed to the reused container via the Policy API
+			Log.Warn("the bundle signature is not verified by the reused OPA server")
+		} else {
+			verificationFlags, keyCopy, err := signing.VerificationFlags(bundle)
+			if err != nil {
+				Log.Fatal(err)
+			}
+			defer os.Remove(keyCopy)
+			opaFlags = append(opaFlags[:len(opaFlags):len(opaFlags)], verificationFlags...)
+		}
+	}
+	server, err := startOpaServer(startCtx, bundle, reused, opaFlags...)
 	if err != nil {
 		Log.Fatal(err)
 	}
-	name, _ := server.Container.Name(ctx)
-	Log.Info("OPA Server started (container: %s)", name)
+	Log.Info("OPA Server started (container: %s)", server.Name(startCtx))
 
-	report := RunTests(tests, *workers, server.Address)
-	err = server.Container.Terminate(ctx)
+	var progress func(*TestResult)
+	if *format != TapFormat {
+		progress = console.Progress
+	}
+	report := RunTests(tests, *workers, server.Address, progress)
+	report.BundleSHA256 = digest
+	// The start timeout may well have expired while running the tests
+	ctx := context.Background()
+	if *opaLogs != "" {
+		if err := saveOpaLogs(ctx, server, *opaLogs, report); err != nil {
+			Log.Error("cannot save the OPA server logs: %v", err)
+		}
+	}
+	err = server.Stop(ctx)
 	if err != nil {
 		Log.Error("failed to stop OPA container: %v", err)
 	}
+	if *explain && report.Failed > 0 {
+		ExplainFailures(context.Background(), policies, tests, report)
+	}
+	// The Rego tests are only reported: coverage and rule bodies are only collected for the Testcases
+	reported := tests
+	if *regoTests {
+		units, err := RunRegoTests(context.Background(), policies, *src, &filter, report)
+		if err != nil {
+			Log.Error("cannot run the Rego tests: %v", err)
+			return 1
+		}
+		if len(units) > 0 {
+			Log.Info("%d Rego tests run from %s", len(units), *src)
+			reported = append(tests[:len(tests):len(tests)], units...)
+		}
+	}
 	encoder := json.NewEncoder(file)
 	err = encoder.Encode(report)
 	if err != nil {
 		Log.Fatal(err)
 	}
 	elapsed := time.Since(start)
-	b, _ := json.MarshalIndent(report, "", "    ")
-	fmt.Println(string(b))
+	switch *format {
+	case TapFormat:
+		err = WriteTAP(os.Stdout, reported, report)
+	case GithubFormat:
+		console.Report(reported, report, elapsed)
+		err = WriteGithubAnnotations(os.Stdout, reported, report)
+	case HtmlFormat:
+		console.Report(reported, report, elapsed)
+		err = writeHtmlReport(htmlReportPath(*out), reported, report, elapsed)
+	default:
+		console.Report(reported, report, elapsed)
+	}
+	if err != nil {
+		Log.Error("cannot write the results: %v", err)
+	}
 	Log.Info("Took %v -- Test results saved to %s", elapsed, *out)
+
+	// Without a baseline, any failed test fails the run, otherwise only regressions do
+	status := 0
+	if baseline != nil {
+		comparison := Compare(baseline, report, &filter, generated)
+		if *format != TapFormat {
+			console.ReportComparison(*baselinePath, comparison)
+		}
+		if regressions := comparison.Regressions(); len(regressions) > 0 {
+			Log.Error("%d regressions since %s", len(regressions), *baselinePath)
+			status = 1
+		}
+	} else if report.Failed > 0 {
+		status = 1
+	}
+
+	if *coverageDir != "" || *minCoverage > 0 {
+		coverage, err := CollectCoverage(context.Background(), policies, tests)
+		if err != nil {
+			Log.Error("cannot collect the policies coverage: %v", err)
+			return 1
+		}
+		Log.Info("Policies coverage: %.1f%%", coverage.Coverage)
+		if *coverageDir != "" {
+			if err := writeCoverage(*coverageDir, coverage); err != nil {
+				Log.Error("cannot save the coverage reports: %v", err)
+				return 1
+			}
+			Log.Info("Coverage reports saved to %s", *coverageDir)
+		}
+		if coverage.Coverage < *minCoverage {
+			Log.Error("policies coverage %.1f%% is below the %.1f%% threshold",
+				coverage.Coverage, *minCoverage)
+			return 1
+		}
+	}
+
+	if *bodiesReport != "" || *failUncovered {
+		bodies, err := CollectRuleBodies(context.Background(), policies, tests)
+		if err != nil {
+			Log.Error("cannot collect the rule bodies: %v", err)
+			return 1
+		}
+		if *bodiesReport != "" {
+			if err := writeJson(*bodiesReport, bodies); err != nil {
+				Log.Error("cannot save the rule bodies report: %v", err)
+				return 1
+			}
+			Log.Info("Rule bodies report saved to %s", *bodiesReport)
+		}
+		uncovered := bodies.Uncovered()
+		for _, body := range uncovered {
+			Log.Warn("%s never decided a test expected to be true", body)
+		}
+		if *failUncovered && len(uncovered) > 0 {
+			Log.Error("%d of %d rule bodies never decided a test expected to be true",
+				len(uncovered), len(bodies.Bodies))
+			return 1
+		}
+	}
+	return status
+}
+
+// startOpaServer starts the OPA server with the `bundle` in a new container (adding the
+// `flags` to its command) or, if `reused` is the name of a container, in that one (see
+// ReuseOpaContainer).
+func startOpaServer(ctx context.Context, bundle string, reused string, flags ...string) (*OpaServer, error) {
+	if reused == "" {
+		return NewOpaContainer(ctx, bundle, flags...)
+	}
+	return ReuseOpaContainer(ctx, reused, bundle)
+}
+
+// saveOpaLogs saves the logs of the OPA `server` to `path`, and attaches the decision logs
+// to the failed tests in the `report`.
+func saveOpaLogs(ctx context.Context, server *OpaServer, path string, report *TestReport) error {
+	logs, err := server.Logs(ctx)
+	if err != nil {
+		return err
+	}
+	EnsureReportDir(path)
+	if err := os.WriteFile(path, logs, 0640); err != nil {
+		return err
+	}
+	attached := AttachDecisionLogs(logs, report)
+	Log.Info("OPA server logs saved to %s (decision logs of %d failed tests added to the report)", path, attached)
+	return nil
 }
 
 func EnsureReportDir(report string) {
 	dir, _ := filepath.Split(report)
+	if dir == "" {
+		return
+	}
 	_, err := os.Stat(dir)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -151,3 +460,47 @@ func EnsureReportDir(report string) {
 		}
 	}
 }
+
+// writeJson saves `v` as (indented) JSON to the file at `path`.
+func writeJson(path string, v interface{}) error {
+	EnsureReportDir(path)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return encodeJson(f, v)
+}
+
+func encodeJson(f *os.File, v interface{}) error {
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(v)
+}
+
+func validFormat(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// htmlReportPath returns the path of the HTML report, alongside the JSON one at `out`.
+func htmlReportPath(out string) string {
+	return strings.TrimSuffix(out, filepath.Ext(out)) + ".html"
+}
+
+func writeHtmlReport(path string, tests []TestUnit, report *TestReport, elapsed time.Duration) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = WriteHTML(f, tests, report, elapsed); err != nil {
+		return err
+	}
+	Log.Info("HTML report saved to %s", path)
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2022 CopilotIQ Inc.  All rights reserved
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	. "github.com/CopilotIQ/opa-tests/testing/internals"
+	slf4go "github.com/massenz/slf4go/logging"
+	"os"
+	"time"
+)
+
+const MutationsOut = "out/reports/mutations.json"
+
+// mutate runs the tests against mutants of the policies (each with a small change, which
+// the tests should detect), and reports the mutants which survived; it returns the
+// process exit code.
+func mutate(args []string) int {
+	flags := flag.NewFlagSet("mutate", flag.ExitOnError)
+	manifest := flags.String("manifest", Manifest, "Path to the manifest file")
+	src := flags.String("src", Sources, "Path to policies (Rego)")
+	out := flags.String("out", MutationsOut, "Path to the mutation testing report")
+	workers := flags.Uint("workers", 0, "Number of parallel threads to run")
+	minScore := flags.Float64("min-score", 0,
+		"Fails if the mutation score (the percentage of killed mutants) is below this threshold")
+	debug := flags.Bool("v", false, "Enable verbose logging")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s mutate [-v] [-manifest MANIFEST] [-src SRC] [-out REPORT] "+
+			"[-min-score PCT] [TESTS]\n\n", ProgName)
+		flags.PrintDefaults()
+		//goland:noinspection GoPrintFunctions
+		fmt.Printf("\nRuns the tests in the TESTS folder (default \"%s\") against mutants of the "+
+			"policies, and reports those which no test detected\n", Tests)
+	}
+	_ = flags.Parse(args)
+	if *debug {
+		Log.Level = slf4go.DEBUG
+	}
+
+	testsDir := flags.Arg(0)
+	if testsDir == "" {
+		testsDir = Tests
+	}
+	tests, err := Generate(testsDir)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	policies := checkPolicies(*src, tests)
+	if policies == nil {
+		return 1
+	}
+	start := time.Now()
+
+	// The mutants are only killed by the tests which pass against the original policies.
+	bundle, err := CreateBundle(*manifest, *src)
+	if err != nil {
+		Log.Error("cannot create the bundle: %v", err)
+		return 1
+	}
+	defer os.Remove(bundle)
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultOpaContainerStartTimeout)
+	server, err := NewOpaContainer(ctx, bundle)
+	cancel()
+	if err != nil {
+		Log.Error("cannot start the OPA server: %v", err)
+		return 1
+	}
+	baseline := RunTests(tests, *workers, server.Address, nil)
+	if err = server.Container.Terminate(context.Background()); err != nil {
+		Log.Error("failed to stop OPA container: %v", err)
+	}
+	if baseline.Succeeded == 0 {
+		Log.Error("none of the %d tests passed against the original policies", baseline.Total)
+		return 1
+	}
+	if baseline.Failed > 0 {
+		Log.Warn("%d failed tests will not be used to kill mutants", baseline.Failed)
+	}
+
+	mutants := GenerateMutants(policies)
+	Log.Info("Generated %d mutants from %s", len(mutants), *src)
+	var report MutationReport
+	for i, mutant := range mutants {
+		// Each mutant's OPA server has its own start timeout, so that one which hangs
+		// does not stall the whole run.
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultOpaContainerStartTimeout)
+		result := mutant.Run(ctx, policies, *manifest, tests, *workers, baseline)
+		cancel()
+		Log.Debug("[%d/%d] %s: %s", i+1, len(mutants), mutant, result.Status)
+		if result.Status == MutantInvalid {
+			Log.Debug("%s: %s", mutant, result.Error)
+		}
+		report.Add(result)
+	}
+	if err := writeJson(*out, &report); err != nil {
+		Log.Error("cannot save the mutation testing report: %v", err)
+		return 1
+	}
+	for _, survivor := range report.Survivors() {
+		fmt.Println(survivor)
+	}
+	fmt.Printf("%d mutants: %d killed, %d survived, %d invalid -- mutation score: %.1f%%\n",
+		len(report.Mutants), report.Killed, report.Survived, report.Invalid, report.Score)
+	Log.Info("Took %v -- Mutation testing report saved to %s", time.Since(start), *out)
+
+	if report.Score < *minScore {
+		Log.Error("mutation score %.1f%% is below the %.1f%% threshold", report.Score, *minScore)
+		return 1
+	}
+	return 0
+}
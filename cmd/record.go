@@ -0,0 +1,93 @@
+// Copyright (c) 2022 CopilotIQ Inc.  All rights reserved
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	. "github.com/CopilotIQ/opa-tests/testing/internals"
+	slf4go "github.com/massenz/slf4go/logging"
+	"os"
+	"strings"
+)
+
+const GoldenDir = "src/tests/golden"
+
+// record runs the tests, and saves the request sent to OPA, and its response, for each
+// of them as a golden file (or, with -check-golden, compares them with the golden files);
+// it returns the process exit code.
+func record(args []string) int {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	manifest := flags.String("manifest", Manifest, "Path to the manifest file")
+	src := flags.String("src", Sources, "Path to policies (Rego)")
+	golden := flags.String("golden", GoldenDir, "Directory of the golden files")
+	check := flags.Bool("check-golden", false,
+		"Fails if any of the requests, or responses, differs from the golden files (which are not modified)")
+	workers := flags.Uint("workers", 0, "Number of parallel threads to run")
+	debug := flags.Bool("v", false, "Enable verbose logging")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s record [-v] [-manifest MANIFEST] [-src SRC] [-golden DIR] [-check-golden] "+
+			"[TESTS]\n\n", ProgName)
+		flags.PrintDefaults()
+		//goland:noinspection GoPrintFunctions
+		fmt.Printf("\nRuns the tests in the TESTS folder (default \"%s\"), and saves the request and "+
+			"response of each of them as a golden file\n", Tests)
+	}
+	_ = flags.Parse(args)
+	if *debug {
+		Log.Level = slf4go.DEBUG
+	}
+
+	testsDir := flags.Arg(0)
+	if testsDir == "" {
+		testsDir = Tests
+	}
+	tests, err := Generate(testsDir)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	bundle, err := CreateBundle(*manifest, *src)
+	if err != nil {
+		Log.Error("cannot create the bundle: %v", err)
+		return 1
+	}
+	defer os.Remove(bundle)
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultOpaContainerStartTimeout)
+	server, err := NewOpaContainer(ctx, bundle)
+	cancel()
+	if err != nil {
+		Log.Error("cannot start the OPA server: %v", err)
+		return 1
+	}
+	report := RunTests(tests, *workers, server.Address, nil)
+	if err = server.Container.Terminate(context.Background()); err != nil {
+		Log.Error("failed to stop OPA container: %v", err)
+	}
+
+	if *check {
+		drifts := CheckGolden(*golden, tests, report)
+		for _, drift := range drifts {
+			fmt.Println(drift)
+		}
+		fmt.Printf("%d drifts from the golden files in %s (of %d tests)\n",
+			len(drifts), *golden, len(report.Results))
+		if len(drifts) > 0 {
+			return 1
+		}
+		return 0
+	}
+	count, err := WriteGolden(*golden, report)
+	if err != nil {
+		Log.Error("cannot save the golden files: %v", err)
+		return 1
+	}
+	if stale, err := StaleGolden(*golden, tests); err == nil && len(stale) > 0 {
+		Log.Warn("%d golden files have no test (remove them, or run with -check-golden to fail): %s",
+			len(stale), strings.Join(stale, ", "))
+	}
+	fmt.Printf("%d golden files saved to %s\n", count, *golden)
+	return 0
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2022 CopilotIQ Inc.  All rights reserved
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	. "github.com/CopilotIQ/opa-tests/testing/internals"
+	slf4go "github.com/massenz/slf4go/logging"
+	"os"
+	"time"
+)
+
+// replay evaluates the decisions logged by OPA against the policies, and reports all
+// those whose result is no longer the logged one; it returns the process exit code.
+func replay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	manifest := flags.String("manifest", Manifest, "Path to the manifest file")
+	src := flags.String("src", Sources, "Path to policies (Rego)")
+	sample := flags.Float64("sample", 1, "Only replays a random sample of the decisions, at this rate (0-1)")
+	seed := flags.Int64("seed", 0, "Seed for the random sample (default: a new sample at every run)")
+	dedupe := flags.Bool("dedupe", false, "Only replays once the decisions with the same path and input")
+	redact := flags.Bool("redact", false, "Redacts the JWTs in the inputs of the reported decisions")
+	out := flags.String("out", "", "Path to the (JSON) report of the mismatched decisions")
+	workers := flags.Uint("workers", 0, "Number of parallel threads to run")
+	debug := flags.Bool("v", false, "Enable verbose logging")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s replay [-v] [-manifest MANIFEST] [-src SRC] [-sample RATE] [-seed SEED] "+
+			"[-dedupe] [-redact] [-out REPORT] DECISIONS [DECISIONS ...]\n\n", ProgName)
+		flags.PrintDefaults()
+		fmt.Printf("\nReplays the decisions in the DECISIONS logs (JSONL) against the policies, " +
+			"and reports those whose result changed\n")
+	}
+	_ = flags.Parse(args)
+	if *debug {
+		Log.Level = slf4go.DEBUG
+	}
+	if flags.NArg() == 0 || *sample <= 0 || *sample > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	var decisions []Decision
+	for _, path := range flags.Args() {
+		logged, err := ReadCorpus(path)
+		if err != nil {
+			Log.Error("cannot read the decisions: %v", err)
+			return 1
+		}
+		decisions = append(decisions, logged...)
+	}
+	total := len(decisions)
+	if *dedupe {
+		decisions = Dedupe(decisions)
+	}
+	if *sample < 1 {
+		if *seed == 0 {
+			*seed = time.Now().UnixNano()
+		}
+		Log.Info("Sampling decisions with seed %d", *seed)
+		decisions = Sample(decisions, *sample, *seed)
+	}
+	Log.Info("Replaying %d of %d decisions", len(decisions), total)
+
+	bundle, err := CreateBundle(*manifest, *src)
+	if err != nil {
+		Log.Error("cannot create the bundle: %v", err)
+		return 1
+	}
+	defer os.Remove(bundle)
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultOpaContainerStartTimeout)
+	server, err := NewOpaContainer(ctx, bundle)
+	cancel()
+	if err != nil {
+		Log.Error("cannot start the OPA server: %v", err)
+		return 1
+	}
+	mismatches := ReplayDecisions(server.Address, decisions, *workers)
+	if err = server.Container.Terminate(context.Background()); err != nil {
+		Log.Error("failed to stop OPA container: %v", err)
+	}
+
+	for i := range mismatches {
+		if *redact {
+			mismatches[i].Input = RedactTokens(mismatches[i].Input)
+		}
+		fmt.Println(mismatches[i])
+	}
+	fmt.Printf("%d of %d replayed decisions do not match the logged result\n", len(mismatches), len(decisions))
+	if *out != "" {
+		if err := writeJson(*out, mismatches); err != nil {
+			Log.Error("cannot save the report: %v", err)
+			return 1
+		}
+		Log.Info("Mismatched decisions saved to %s", *out)
+	}
+	if len(mismatches) > 0 {
+		return 1
+	}
+	return 0
+}
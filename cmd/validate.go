@@ -0,0 +1,78 @@
+// Copyright (c) 2022 CopilotIQ Inc.  All rights reserved
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	. "github.com/CopilotIQ/opa-tests/testing/internals"
+	slf4go "github.com/massenz/slf4go/logging"
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// validate compiles the Rego policies and verifies that the targets of all the
+// Testcases resolve to a rule; it returns the process exit code.
+func validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	src := flags.String("src", Sources, "Path to policies (Rego)")
+	debug := flags.Bool("v", false, "Enable verbose logging (lists all the rules)")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s validate [-v] [-src SRC] [TESTS]\n\n", ProgName)
+		flags.PrintDefaults()
+		//goland:noinspection GoPrintFunctions
+		fmt.Printf("\nValidates the policies, and the targets of the tests in the TESTS folder "+
+			"(default \"%s\")\n", Tests)
+	}
+	_ = flags.Parse(args)
+	if *debug {
+		Log.Level = slf4go.DEBUG
+	}
+
+	testsDir := flags.Arg(0)
+	if testsDir == "" {
+		testsDir = Tests
+	}
+	tests, err := Generate(testsDir)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	policies := checkPolicies(*src, tests)
+	if policies == nil {
+		return 1
+	}
+	fmt.Printf("%d rules defined in %d modules, all %d tests' targets resolved\n",
+		len(policies.Rules()), len(policies.Modules), len(tests))
+	return 0
+}
+
+// checkPolicies loads the policies from `src`, and verifies that all the `tests` targets
+// resolve to a rule: all the problems found are reported, and nil is returned.
+func checkPolicies(src string, tests []TestUnit) *Policies {
+	policies, err := LoadPolicies(src)
+	if err != nil {
+		if errs, ok := err.(ast.Errors); ok {
+			for _, e := range errs {
+				fmt.Println(e)
+			}
+		} else {
+			fmt.Println(err)
+		}
+		Log.Error("cannot compile the policies in %s", src)
+		return nil
+	}
+	for _, rule := range policies.Rules() {
+		Log.Debug("- %s", rule)
+	}
+	unresolved := ValidateTargets(policies, tests)
+	for _, target := range unresolved {
+		fmt.Println(target)
+	}
+	if len(unresolved) > 0 {
+		Log.Error("%d targets do not match any of the %d rules defined in %s",
+			len(unresolved), len(policies.Rules()), src)
+		return nil
+	}
+	return policies
+}
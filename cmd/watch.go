@@ -0,0 +1,203 @@
+// Copyright (c) 2022 CopilotIQ Inc.  All rights reserved
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	. "github.com/CopilotIQ/opa-tests/testing/internals"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// unsupportedWatchFlags are the flags which have no effect in watch mode, as the policies
+// are not bundled, nor are the results saved.
+var unsupportedWatchFlags = []string{"x", "bundle", "out", "format", "baseline", "ci", "coverage",
+	"min-coverage", "rule-bodies", "fail-uncovered", "explain", "templates", "reuse", "container",
+	"opa-logs", "signing-key", "signing-alg", "signing-key-id", "verification-key"}
+
+// checkWatchFlags returns an error if any of the unsupportedWatchFlags was given (except
+// for the console -format, which is the one used in watch mode).
+func checkWatchFlags() error {
+	var given []string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "format" && f.Value.String() == ConsoleFormat {
+			return
+		}
+		for _, name := range unsupportedWatchFlags {
+			if f.Name == name {
+				given = append(given, "-"+name)
+			}
+		}
+	})
+	if len(given) > 0 {
+		return fmt.Errorf("%s cannot be used with -watch", strings.Join(given, ", "))
+	}
+	return nil
+}
+
+// A suiteWatcher reruns the tests affected by the changes to the policies, the manifest
+// and the Testcases, against an OPA server which is kept running between runs.
+type suiteWatcher struct {
+	manifest  string
+	src       string
+	testsDir  string
+	filter    Filter
+	workers   uint
+	regoTests bool
+	console   *ConsoleReporter
+
+	addr string
+	// reloadAll is set when the policies on the server may be stale (e.g., after a
+	// compilation error), so that all of them are uploaded, and all tests run, again.
+	reloadAll bool
+}
+
+// patterns are the globs of the files watched for changes.
+func (w *suiteWatcher) patterns() []string {
+	tests := w.testsDir
+	if info, err := os.Stat(tests); err == nil && info.IsDir() {
+		tests = filepath.Join(tests, YamlGlob)
+	}
+	return []string{filepath.Join(w.src, PoliciesGlob), w.manifest, tests}
+}
+
+// watch runs all the tests, then the affected ones whenever any of the watched files
+// changes, until interrupted; it returns the process exit code of the last run.
+func (w *suiteWatcher) watch(interval time.Duration) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The policies are uploaded via the Policy API, as OPA does not allow modifying
+	// those loaded from a bundle.
+	startCtx, cancel := context.WithTimeout(ctx, DefaultOpaContainerStartTimeout)
+	defer cancel()
+	server, err := NewOpaContainer(startCtx, "")
+	if err != nil {
+		Log.Error("cannot start the OPA server: %v", err)
+		return 1
+	}
+	defer func() {
+		if err := server.Container.Terminate(context.Background()); err != nil {
+			Log.Error("failed to stop OPA container: %v", err)
+		}
+	}()
+	w.addr = server.Address
+
+	watcher, err := NewWatcher(w.patterns()...)
+	if err != nil {
+		Log.Error("cannot watch the files: %v", err)
+		return 1
+	}
+	w.reloadAll = true
+	status := w.run(nil)
+	fmt.Printf("\nWatching %s for changes (press Ctrl-C to stop)\n", strings.Join(watcher.Patterns, ", "))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return status
+		case <-ticker.C:
+			changed, err := watcher.Changes()
+			if err != nil {
+				Log.Error("cannot watch the files: %v", err)
+				return 1
+			}
+			if len(changed) == 0 {
+				continue
+			}
+			fmt.Printf("\nChanged: %s\n", strings.Join(changed, ", "))
+			status = w.run(changed)
+		}
+	}
+}
+
+// run uploads the `changed` policies, and runs the tests they may affect; it returns
+// the exit code of the run.
+func (w *suiteWatcher) run(changed []string) int {
+	start := time.Now()
+	tests, err := Generate(w.testsDir)
+	if err != nil {
+		fmt.Println(err)
+		// The changed policies are not uploaded
+		w.reloadAll = true
+		return 1
+	}
+	tests = w.filter.Apply(tests)
+	policies := checkPolicies(w.src, tests)
+	if policies == nil {
+		w.reloadAll = true
+		return 1
+	}
+
+	// The tests of the rules defined in a removed Rego file can no longer be found (as
+	// the policies no longer have them), so that they are all run, as for the manifest.
+	all := w.reloadAll
+	for _, file := range changed {
+		all = all || filepath.Clean(file) == filepath.Clean(w.manifest) || isRemovedPolicy(file)
+	}
+	// On a full reload, the policies whose files were removed meanwhile are deleted too.
+	if all {
+		files, _ := filepath.Glob(filepath.Join(w.src, PoliciesGlob))
+		err = ReplacePolicies(w.addr, files)
+	} else {
+		err = PutPolicies(w.addr, changed)
+	}
+	if err != nil {
+		Log.Error("cannot load the policies: %v", err)
+		w.reloadAll = true
+		return 1
+	}
+	w.reloadAll = false
+
+	affected := tests
+	if !all {
+		affected = AffectedTests(policies, tests, changed)
+	}
+	report := RunTests(affected, w.workers, w.addr, w.console.Progress)
+	reported := affected
+	if w.regoTests && (all || changesPolicies(changed)) {
+		units, err := RunRegoTests(context.Background(), policies, w.src, &w.filter, report)
+		if err != nil {
+			Log.Error("cannot run the Rego tests: %v", err)
+			return 1
+		}
+		reported = append(affected[:len(affected):len(affected)], units...)
+	}
+	if len(reported) == 0 {
+		fmt.Println("No tests affected")
+		return 0
+	}
+	w.console.Report(reported, report, time.Since(start))
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// isRemovedPolicy is true if the `file` is a Rego file which no longer exists.
+func isRemovedPolicy(file string) bool {
+	if matched, _ := filepath.Match(PoliciesGlob, filepath.Base(file)); !matched {
+		return false
+	}
+	_, err := os.Stat(file)
+	return os.IsNotExist(err)
+}
+
+// changesPolicies is true if any of the `changed` files is a Rego file.
+func changesPolicies(changed []string) bool {
+	for _, file := range changed {
+		if matched, _ := filepath.Match(PoliciesGlob, filepath.Base(file)); matched {
+			return true
+		}
+	}
+	return false
+}
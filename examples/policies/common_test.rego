@@ -0,0 +1,24 @@
+# Unit tests for the common functionality, run by `opa test` (and `opatest`)
+#
+# Test modules (`*_test.rego`) are not included in the bundle.
+
+package copilotiq.common
+import future.keywords.in
+
+test_split_path {
+    split_path("/users/1234/roles?active=true") == ["users", "1234", "roles"]
+}
+
+test_entity {
+    entity == "users" with input as {"resource": {"path": "/users/1234"}}
+    entity_id == "1234" with input as {"resource": {"path": "/users/1234"}}
+}
+
+test_is_manager {
+    is_manager with roles as ["USER", "ORG_MANAGER"]
+    not is_manager with roles as ["USER"]
+}
+
+todo_test_is_system {
+    is_system with roles as ["SYSTEM"]
+}
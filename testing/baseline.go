@@ -0,0 +1,116 @@
+// Copyright (c) 2022 CopilotIQ Inc.  All rights reserved
+
+package testing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// A Comparison classifies the tests of a run, by comparing their results with those
+// of a previous (baseline) run; skipped tests are ignored.
+type Comparison struct {
+	NewlyFailing []string `json:",omitempty"`
+	NewlyPassing []string `json:",omitempty"`
+	StillFailing []string `json:",omitempty"`
+	// Added are the tests which were not run in the baseline, whether they passed or failed
+	Added []string `json:",omitempty"`
+	// Removed are the tests which were run in the baseline, and no longer exist
+	Removed []string `json:",omitempty"`
+	// Unselected are the tests which were run in the baseline, but not selected to run now
+	// (see Filter); they are not compared
+	Unselected []string `json:",omitempty"`
+
+	// AddedFailing are those of the Added tests which failed
+	AddedFailing []string `json:",omitempty"`
+}
+
+// Regressions returns the tests which fail now, but did not in the baseline: those
+// newly failing, and the added ones which failed.
+func (c *Comparison) Regressions() []string {
+	var regressions = append(append([]string{}, c.NewlyFailing...), c.AddedFailing...)
+	sort.Strings(regressions)
+	return regressions
+}
+
+// LoadReport reads a TestReport saved as JSON (e.g., the `results.json` of a previous run).
+//
+// The reports of the earlier releases have no Results, only the FailedNames: the Results
+// of those failed tests are added, so that they are not compared as new failures; the
+// tests which passed are not known, and are compared as Added.
+func LoadReport(path string) (*TestReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report TestReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("invalid report %s: %v", path, err)
+	}
+	if len(report.Results) == 0 && len(report.FailedNames) > 0 {
+		Log.Warn("%s has no test results (it was saved by an earlier release): only its %d failed tests "+
+			"are compared", path, len(report.FailedNames))
+		for _, name := range report.FailedNames {
+			report.Results = append(report.Results, &TestResult{Name: name})
+		}
+	}
+	return &report, nil
+}
+
+// Compare classifies all the tests in the `current` report, based on their results
+// in the `baseline` one; all the lists of names are sorted.
+//
+// The `filter` (if not nil) selected the tests run now among the `generated` ones: the
+// baseline tests it excludes are Unselected, rather than Removed; those which are not
+// among the `generated` tests (e.g., the Rego tests) are only matched by name and target.
+func Compare(baseline, current *TestReport, filter *Filter, generated []TestUnit) *Comparison {
+	var comparison Comparison
+	var previous = resultsByName(baseline)
+	var skipped = make(map[string]bool, len(current.SkippedNames))
+	for _, name := range current.SkippedNames {
+		skipped[name] = true
+	}
+	for _, result := range current.Results {
+		before, found := previous[result.Name]
+		delete(previous, result.Name)
+		switch {
+		case !found:
+			comparison.Added = append(comparison.Added, result.Name)
+			if !result.Passed {
+				comparison.AddedFailing = append(comparison.AddedFailing, result.Name)
+			}
+		case before.Passed && !result.Passed:
+			comparison.NewlyFailing = append(comparison.NewlyFailing, result.Name)
+		case !before.Passed && result.Passed:
+			comparison.NewlyPassing = append(comparison.NewlyPassing, result.Name)
+		case !result.Passed:
+			comparison.StillFailing = append(comparison.StillFailing, result.Name)
+		}
+	}
+	var units = make(map[string]*TestUnit, len(generated))
+	for i := range generated {
+		units[generated[i].Name] = &generated[i]
+	}
+	for name, before := range previous {
+		if skipped[name] {
+			continue
+		}
+		unit, found := units[name]
+		if !found {
+			unit = &TestUnit{Name: name, Endpoint: before.Endpoint}
+		}
+		if filter != nil && !filter.Matches(unit) {
+			comparison.Unselected = append(comparison.Unselected, name)
+		} else {
+			comparison.Removed = append(comparison.Removed, name)
+		}
+	}
+	for _, names := range [][]string{comparison.NewlyFailing, comparison.NewlyPassing,
+		comparison.StillFailing, comparison.Added, comparison.Removed, comparison.Unselected,
+		comparison.AddedFailing} {
+		sort.Strings(names)
+	}
+	return &comparison
+}
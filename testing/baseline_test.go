@@ -0,0 +1,113 @@
+package testing_test
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+func reportOf(passed []string, failed []string, skipped ...string) *TestReport {
+	var report TestReport
+	for _, name := range passed {
+		report.ReportSuccess(&TestResult{Name: name})
+	}
+	for _, name := range failed {
+		report.ReportFailure(&TestResult{Name: name})
+	}
+	for _, name := range skipped {
+		report.ReportSkipped(name, "skipped")
+	}
+	return &report
+}
+
+var _ = Describe("Baseline", func() {
+	var comparison *Comparison
+	BeforeEach(func() {
+		baseline := reportOf([]string{"a", "b", "gone"}, []string{"c", "d", "was_skipped"})
+		current := reportOf([]string{"a", "c", "new_pass"}, []string{"d", "b", "new_fail"}, "was_skipped")
+		comparison = Compare(baseline, current, nil, nil)
+	})
+
+	It("classifies the tests", func() {
+		Expect(comparison.NewlyFailing).To(Equal([]string{"b"}))
+		Expect(comparison.NewlyPassing).To(Equal([]string{"c"}))
+		Expect(comparison.StillFailing).To(Equal([]string{"d"}))
+		Expect(comparison.Added).To(Equal([]string{"new_fail", "new_pass"}))
+		Expect(comparison.Removed).To(Equal([]string{"gone"}))
+	})
+	It("does not report the tests which were not selected as removed", func() {
+		baseline := reportOf([]string{"Users.a", "Users.tagged", "Users.other_target", "Admins.a", "gone"}, nil)
+		baseline.Results[2].Endpoint = "copilotiq/other"
+		current := reportOf([]string{"Users.a"}, nil)
+		generated := []TestUnit{{Name: "Users.a", Endpoint: "copilotiq/allow"}, {Name: "Users.tagged", Tags: []string{"slow"}},
+			{Name: "Users.other_target", Endpoint: "copilotiq/other"}, {Name: "Admins.a"}}
+		filter := &Filter{Run: regexp.MustCompile("^Users"), ExcludeTags: []string{"slow"},
+			Targets: []string{"copilotiq/allow"}}
+		comparison := Compare(baseline, current, filter, generated)
+		Expect(comparison.Removed).To(BeEmpty())
+		Expect(comparison.Unselected).To(Equal([]string{"Admins.a", "Users.other_target", "Users.tagged", "gone"}))
+
+		filter.Run = nil
+		filter.Targets = nil
+		comparison = Compare(baseline, current, filter, generated)
+		Expect(comparison.Removed).To(Equal([]string{"Admins.a", "Users.other_target", "gone"}))
+
+		var out bytes.Buffer
+		console := &ConsoleReporter{Out: &out, Verbosity: Quiet}
+		console.ReportComparison("old.json", comparison)
+		Expect(out.String()).To(HaveSuffix("3 removed\n  (not compared: 1 baseline tests not selected to run)\n"))
+	})
+	It("only reports failures as regressions if they did not fail before", func() {
+		Expect(comparison.Regressions()).To(Equal([]string{"b", "new_fail"}))
+		Expect(Compare(reportOf(nil, []string{"a"}), reportOf(nil, []string{"a"}), nil, nil).Regressions()).
+			To(BeEmpty())
+	})
+	It("loads a previous report", func() {
+		dir, err := os.MkdirTemp("", "baseline-*")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "results.json")
+		data, err := json.Marshal(reportOf([]string{"a"}, []string{"b"}))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(os.WriteFile(path, data, 0600)).To(Succeed())
+		report, err := LoadReport(path)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(report.Succeeded).To(Equal(uint(1)))
+		Expect(report.Results).To(HaveLen(2))
+		Expect(report.Results[1].Passed).To(BeFalse())
+
+		Expect(os.WriteFile(path, []byte("{"), 0600)).To(Succeed())
+		_, err = LoadReport(path)
+		Expect(err).To(MatchError(ContainSubstring("invalid report")))
+	})
+	It("loads a report of an earlier release, with only the failed tests' names", func() {
+		dir, err := os.MkdirTemp("", "baseline-*")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "results.json")
+		Expect(os.WriteFile(path, []byte(`{"Succeeded": 2, "Failed": 2, "Total": 4, "FailedNames": ["c", "d"]}`),
+			0600)).To(Succeed())
+		baseline, err := LoadReport(path)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(baseline.Results).To(HaveLen(2))
+
+		current := reportOf([]string{"a", "c"}, []string{"b", "d"})
+		comparison := Compare(baseline, current, nil, nil)
+		Expect(comparison.StillFailing).To(Equal([]string{"d"}))
+		Expect(comparison.NewlyPassing).To(Equal([]string{"c"}))
+		Expect(comparison.Regressions()).To(Equal([]string{"b"}))
+	})
+	It("is shown on the console", func() {
+		var out bytes.Buffer
+		console := &ConsoleReporter{Out: &out, Verbosity: Normal}
+		console.ReportComparison("old.json", comparison)
+		Expect(out.String()).To(Equal("\nCompared with old.json: 1 newly failing, 1 newly passing, " +
+			"1 still failing, 2 added, 1 removed\n" +
+			"  Newly failing:\n    b\n  Added and failing:\n    new_fail\n  Newly passing:\n    c\n"))
+	})
+})
@@ -0,0 +1,271 @@
+// Copyright (c) 2022 CopilotIQ Inc.  All rights reserved
+
+package testing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// The Verbosity of the ConsoleReporter
+const (
+	// Quiet only shows the failures, and the final counts
+	Quiet = iota
+	// Normal shows the progress, the results by Testcase, the failures and the counts
+	Normal
+	// Verbose also shows each of the tests, with its duration, and the skip reasons
+	Verbose
+)
+
+const (
+	passMark = "✓"
+	failMark = "✗"
+	skipMark = "-"
+
+	green  = "\033[32m"
+	red    = "\033[31m"
+	yellow = "\033[33m"
+	bold   = "\033[1m"
+	faint  = "\033[2m"
+	reset  = "\033[0m"
+)
+
+// A ConsoleReporter shows the tests results in a human-friendly form; colors are
+// only used if Color is true (see IsTerminal).
+type ConsoleReporter struct {
+	Out       io.Writer
+	Color     bool
+	Verbosity int
+
+	progress int
+}
+
+// NewConsoleReporter returns a ConsoleReporter writing to `out`, using colors if it is
+// a terminal (unless the NO_COLOR environment variable is set).
+func NewConsoleReporter(out *os.File, verbosity int) *ConsoleReporter {
+	_, noColor := os.LookupEnv("NO_COLOR")
+	return &ConsoleReporter{Out: out, Color: IsTerminal(out) && !noColor, Verbosity: verbosity}
+}
+
+// IsTerminal returns true if `f` is a terminal (a character device).
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func (c *ConsoleReporter) paint(color string, text string) string {
+	if !c.Color {
+		return text
+	}
+	return color + text + reset
+}
+
+// Progress shows a mark for each result, as it is reported; it is meant to be
+// used as the TestReport.Progress function.
+func (c *ConsoleReporter) Progress(result *TestResult) {
+	if c.Verbosity == Quiet {
+		return
+	}
+	if result.Passed {
+		fmt.Fprint(c.Out, c.paint(green, "."))
+	} else {
+		fmt.Fprint(c.Out, c.paint(red, "F"))
+	}
+	c.progress++
+	if c.progress%80 == 0 {
+		fmt.Fprintln(c.Out)
+	}
+}
+
+// Report shows the results of the `tests` grouped by Testcase, the details of all
+// the failures, and the final counts.
+func (c *ConsoleReporter) Report(tests []TestUnit, report *TestReport, elapsed time.Duration) {
+	if c.progress%80 != 0 {
+		fmt.Fprintln(c.Out)
+	}
+	c.progress = 0
+	var results = resultsByName(report)
+	if c.Verbosity > Quiet {
+		fmt.Fprintln(c.Out)
+		for _, group := range groupByTestcase(tests) {
+			c.reportTestcase(group, results, report.SkipReasons)
+		}
+	}
+	// The failures are shown in the same order as the tests, regardless of which
+	// worker ran them.
+	var failures []*TestResult
+	for _, test := range tests {
+		if result, found := results[test.Name]; found && !result.Passed {
+			failures = append(failures, result)
+		}
+	}
+	if len(failures) > 0 {
+		fmt.Fprintf(c.Out, "\n%s\n", c.paint(bold+red, "Failures:"))
+		for i, failure := range failures {
+			c.reportFailure(i+1, failure)
+		}
+	}
+	counts := []string{
+		c.paint(green, fmt.Sprintf("%d passed", report.Succeeded)),
+		fmt.Sprintf("%d failed", report.Failed),
+		fmt.Sprintf("%d skipped", report.Skipped),
+	}
+	if report.Failed > 0 {
+		counts[1] = c.paint(red, counts[1])
+	}
+	if report.Skipped > 0 {
+		counts[2] = c.paint(yellow, counts[2])
+	}
+	fmt.Fprintf(c.Out, "\n%d tests: %s (%v)\n", report.Total, strings.Join(counts, ", "),
+		elapsed.Round(time.Millisecond))
+}
+
+type testcaseGroup struct {
+	Name  string
+	Tests []TestUnit
+}
+
+// groupByTestcase groups the `tests` by Testcase, preserving their order.
+func groupByTestcase(tests []TestUnit) []testcaseGroup {
+	var groups []testcaseGroup
+	var index = make(map[string]int)
+	for _, test := range tests {
+		i, found := index[test.Testcase]
+		if !found {
+			i = len(groups)
+			index[test.Testcase] = i
+			groups = append(groups, testcaseGroup{Name: test.Testcase})
+		}
+		groups[i].Tests = append(groups[i].Tests, test)
+	}
+	return groups
+}
+
+func (c *ConsoleReporter) reportTestcase(group testcaseGroup, results map[string]*TestResult,
+	skipReasons map[string]string) {
+	var passed, failed, skipped int
+	var lines []string
+	for _, test := range group.Tests {
+		name := strings.TrimPrefix(test.Name, group.Name+".")
+		result, found := results[test.Name]
+		reason, isSkipped := skipReasons[test.Name]
+		switch {
+		case !found && isSkipped:
+			skipped++
+			if c.Verbosity == Verbose {
+				lines = append(lines, fmt.Sprintf("  %s %s %s", c.paint(yellow, skipMark), name,
+					c.paint(faint, fmt.Sprintf("(skipped: %s)", reason))))
+			}
+		case !found:
+			failed++
+			lines = append(lines, fmt.Sprintf("  %s %s %s", c.paint(red, failMark), name,
+				c.paint(faint, "("+notRunMessage+")")))
+		case result.Passed:
+			passed++
+			if c.Verbosity == Verbose {
+				lines = append(lines, fmt.Sprintf("  %s %s %s", c.paint(green, passMark), name,
+					c.paint(faint, result.Duration.Round(time.Microsecond).String())))
+			}
+		default:
+			failed++
+			lines = append(lines, fmt.Sprintf("  %s %s", c.paint(red, failMark), name))
+		}
+	}
+	mark := c.paint(green, passMark)
+	if failed > 0 {
+		mark = c.paint(red, failMark)
+	}
+	summary := fmt.Sprintf("%d/%d passed", passed, passed+failed)
+	if skipped > 0 {
+		summary += fmt.Sprintf(", %d skipped", skipped)
+	}
+	fmt.Fprintf(c.Out, "%s %s %s\n", mark, c.paint(bold, group.Name), c.paint(faint, "("+summary+")"))
+	for _, line := range lines {
+		fmt.Fprintln(c.Out, line)
+	}
+}
+
+func (c *ConsoleReporter) reportFailure(n int, failure *TestResult) {
+	fmt.Fprintf(c.Out, "\n%d) %s %s\n", n, c.paint(bold, failure.Name), c.paint(faint, failure.Endpoint))
+	if failure.File != "" {
+		fmt.Fprintf(c.Out, "   at:       %s:%d\n", failure.File, failure.Line)
+	}
+	if failure.Error != "" {
+		fmt.Fprintf(c.Out, "   error:    %s\n", c.paint(red, failure.Error))
+	}
+	fmt.Fprintf(c.Out, "   expected: %v\n", failure.Expected)
+	fmt.Fprintf(c.Out, "   actual:   %s\n", c.paint(red, formatActual(failure.Actual)))
+	if failure.DecisionID != "" {
+		fmt.Fprintf(c.Out, "   decision: %s\n", failure.DecisionID)
+	}
+	if failure.Body != nil {
+		body, err := json.MarshalIndent(failure.Body, "   ", "  ")
+		if err == nil {
+			fmt.Fprintf(c.Out, "   request:  %s\n", body)
+		}
+	}
+	if failure.Explanation != nil {
+		fmt.Fprintf(c.Out, "   explanation:\n")
+		for _, line := range strings.Split(failure.Explanation.String(), "\n") {
+			fmt.Fprintf(c.Out, "     %s\n", line)
+		}
+	}
+}
+
+func formatActual(actual interface{}) string {
+	if actual == nil {
+		return "undefined"
+	}
+	return fmt.Sprintf("%v", actual)
+}
+
+type comparisonSection struct {
+	title string
+	color string
+	names []string
+}
+
+// ReportComparison shows how the results changed since the `baseline` run: the counts of
+// each class of tests, and the names of those which changed (all of them, if Verbose).
+func (c *ConsoleReporter) ReportComparison(baseline string, comparison *Comparison) {
+	fmt.Fprintf(c.Out, "\nCompared with %s: %s, %s, %d still failing, %d added, %d removed\n", baseline,
+		c.paint(red, fmt.Sprintf("%d newly failing", len(comparison.NewlyFailing))),
+		c.paint(green, fmt.Sprintf("%d newly passing", len(comparison.NewlyPassing))),
+		len(comparison.StillFailing), len(comparison.Added), len(comparison.Removed))
+	if len(comparison.Unselected) > 0 {
+		fmt.Fprintf(c.Out, "  (not compared: %d baseline tests not selected to run)\n", len(comparison.Unselected))
+	}
+	if c.Verbosity == Quiet {
+		return
+	}
+	sections := []comparisonSection{
+		{"Newly failing", red, comparison.NewlyFailing},
+		{"Added and failing", red, comparison.AddedFailing},
+		{"Newly passing", green, comparison.NewlyPassing},
+	}
+	if c.Verbosity == Verbose {
+		sections = append(sections,
+			comparisonSection{"Still failing", yellow, comparison.StillFailing},
+			comparisonSection{"Added", "", comparison.Added},
+			comparisonSection{"Removed", "", comparison.Removed})
+	}
+	for _, section := range sections {
+		if len(section.names) == 0 {
+			continue
+		}
+		fmt.Fprintf(c.Out, "  %s:\n", section.title)
+		for _, name := range section.names {
+			if section.color != "" {
+				name = c.paint(section.color, name)
+			}
+			fmt.Fprintf(c.Out, "    %s\n", name)
+		}
+	}
+}
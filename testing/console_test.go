@@ -0,0 +1,63 @@
+package testing_test
+
+import (
+	"bytes"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"strings"
+	"time"
+)
+
+var _ = Describe("ConsoleReporter", func() {
+	var tests []TestUnit
+	var report *TestReport
+	var out *bytes.Buffer
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+	})
+	run := func(verbosity int) string {
+		console := &ConsoleReporter{Out: out, Verbosity: verbosity}
+		tests, report = reportFixture(console.Progress, func(passed *TestResult, failed *TestResult) {
+			passed.Duration = 2 * time.Millisecond
+			failed.Actual = nil
+			failed.DecisionID = "d1"
+		})
+		console.Report(tests, report, time.Second)
+		return out.String()
+	}
+
+	It("groups the results by Testcase", func() {
+		output := run(Normal)
+		Expect(tests[0].Testcase).To(Equal("Overrides"))
+		Expect(output).To(HavePrefix(".F\n"))
+		Expect(output).To(ContainSubstring("✗ Overrides (1/2 passed, 1 skipped)\n  ✗ override_policy\n"))
+		Expect(output).ToNot(ContainSubstring("✓ default_target"))
+		Expect(output).To(HaveSuffix("\n3 tests: 1 passed, 1 failed, 1 skipped (1s)\n"))
+	})
+	It("shows the failures' details", func() {
+		output := run(Normal)
+		Expect(output).To(ContainSubstring("Failures:\n\n1) Overrides.override_policy " +
+			tests[1].Endpoint + "\n   expected: true\n   actual:   undefined\n   decision: d1\n   request:  {"))
+		Expect(output).To(ContainSubstring(`"method": "GET"`))
+	})
+	It("shows all the tests when verbose", func() {
+		output := run(Verbose)
+		Expect(output).To(ContainSubstring("  ✓ default_target 2ms\n"))
+		Expect(output).To(ContainSubstring("  - override_package (skipped: not ready)\n"))
+	})
+	It("only shows the failures when quiet", func() {
+		output := run(Quiet)
+		Expect(output).To(HavePrefix("\nFailures:\n"))
+		Expect(output).ToNot(ContainSubstring("Overrides (1/2 passed"))
+	})
+	It("does not use colors unless asked to", func() {
+		Expect(strings.Contains(run(Verbose), "\033[")).To(BeFalse())
+		out.Reset()
+		report = &TestReport{}
+		console := &ConsoleReporter{Out: out, Color: true, Verbosity: Normal}
+		console.Progress(&TestResult{Passed: true})
+		Expect(out.String()).To(Equal("\033[32m.\033[0m"))
+	})
+})
@@ -0,0 +1,86 @@
+// Copyright (c) 2022 CopilotIQ Inc.  All rights reserved
+
+package testing
+
+import (
+	"regexp"
+	"strings"
+)
+
+// A Filter selects which of the generated TestUnits will be run; an empty
+// Filter matches all of them.
+type Filter struct {
+	// Run, if not nil, must match the test's name (`Testcase.test`)
+	Run *regexp.Regexp
+	// Skip, if not nil, must not match the test's name
+	Skip *regexp.Regexp
+
+	// The test must carry at least one of the Tags (if any) and none of the ExcludeTags
+	Tags        []string
+	ExcludeTags []string
+
+	// Targets (e.g., `copilotiq/allow`) that the test's Endpoint must match; a
+	// package (e.g., `copilotiq`) matches all the rules within it.
+	Targets []string
+}
+
+// SplitList splits a comma-separated list (as passed to the command-line flags),
+// ignoring empty elements.
+func SplitList(list string) []string {
+	var result []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// Matches returns true if the `test` should be run.
+func (f *Filter) Matches(test *TestUnit) bool {
+	if f.Run != nil && !f.Run.MatchString(test.Name) {
+		return false
+	}
+	if f.Skip != nil && f.Skip.MatchString(test.Name) {
+		return false
+	}
+	if len(f.Tags) > 0 && !hasAny(test.Tags, f.Tags) {
+		return false
+	}
+	if hasAny(test.Tags, f.ExcludeTags) {
+		return false
+	}
+	if len(f.Targets) > 0 {
+		for _, target := range f.Targets {
+			target = strings.Trim(target, "/")
+			if test.Endpoint == target || strings.HasPrefix(test.Endpoint, target+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Apply returns only those `tests` that match the Filter, in the same order.
+func (f *Filter) Apply(tests []TestUnit) []TestUnit {
+	var selected = make([]TestUnit, 0, len(tests))
+	for i := range tests {
+		if f.Matches(&tests[i]) {
+			selected = append(selected, tests[i])
+		}
+	}
+	Log.Debug("selected %d of %d tests", len(selected), len(tests))
+	return selected
+}
+
+func hasAny(tags []string, wanted []string) bool {
+	for _, tag := range tags {
+		for _, w := range wanted {
+			if tag == w {
+				return true
+			}
+		}
+	}
+	return false
+}
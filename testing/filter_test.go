@@ -0,0 +1,56 @@
+package testing_test
+
+import (
+	. "github.com/CopilotIQ/opa-tests/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"regexp"
+)
+
+func names(tests []TestUnit) []string {
+	var result []string
+	for _, t := range tests {
+		result = append(result, t.Name)
+	}
+	return result
+}
+
+var _ = Describe("Filter", func() {
+	var tests []TestUnit
+	BeforeEach(func() {
+		var err error
+		tests, err = Generate(testcasesDir)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("matches all tests when empty", func() {
+		Expect((&Filter{}).Apply(tests)).To(Equal(tests))
+	})
+	It("merges the Testcase and Test tags", func() {
+		Expect(tests[0].Tags).To(Equal([]string{"admin"}))
+		Expect(tests[1].Tags).To(Equal([]string{"admin", "audit"}))
+	})
+	It("selects by name", func() {
+		f := Filter{Run: regexp.MustCompile(`override_`), Skip: regexp.MustCompile(`package$`)}
+		Expect(names(f.Apply(tests))).To(Equal([]string{"Overrides.override_policy"}))
+	})
+	It("selects by tags", func() {
+		f := Filter{Tags: []string{"audit", "other"}}
+		Expect(names(f.Apply(tests))).To(Equal([]string{"Overrides.override_policy"}))
+		f = Filter{Tags: []string{"admin"}, ExcludeTags: []string{"audit"}}
+		Expect(names(f.Apply(tests))).To(Equal([]string{
+			"Overrides.default_target", "Overrides.override_package"}))
+	})
+	It("selects by target", func() {
+		f := Filter{Targets: []string{"copilotiq/allow"}}
+		Expect(names(f.Apply(tests))).To(Equal([]string{"Overrides.default_target"}))
+		f = Filter{Targets: []string{"copilotiq/common"}}
+		Expect(names(f.Apply(tests))).To(Equal([]string{"Overrides.override_package"}))
+		f = Filter{Targets: []string{"copilotiq"}}
+		Expect(f.Apply(tests)).To(HaveLen(3))
+	})
+	It("splits comma-separated lists", func() {
+		Expect(SplitList("a, b,,c ")).To(Equal([]string{"a", "b", "c"}))
+		Expect(SplitList("")).To(BeEmpty())
+	})
+})
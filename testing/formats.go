@@ -0,0 +1,118 @@
+// Copyright (c) 2022 CopilotIQ Inc.  All rights reserved
+
+package testing
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The supported output Formats of the tests results
+const (
+	ConsoleFormat = "console"
+	TapFormat     = "tap"
+	GithubFormat  = "github"
+	HtmlFormat    = "html"
+)
+
+// Formats are all the supported output formats
+var Formats = []string{ConsoleFormat, TapFormat, GithubFormat, HtmlFormat}
+
+// notRunMessage is reported for the tests which were neither run, nor skipped
+// (e.g., if the OPA server could not be reached).
+const notRunMessage = "not run"
+
+// failureMessage describes why the test failed in a single line.
+func failureMessage(result *TestResult) string {
+	if result.Error != "" {
+		return result.Error
+	}
+	return fmt.Sprintf("expected %v, got %s", result.Expected, formatActual(result.Actual))
+}
+
+// WriteTAP writes the results of the `tests` in the Test Anything Protocol (TAP)
+// version 13 format, in the same order as the `tests`.
+func WriteTAP(w io.Writer, tests []TestUnit, report *TestReport) error {
+	var results = resultsByName(report)
+	var b strings.Builder
+	b.WriteString("TAP version 13\n")
+	fmt.Fprintf(&b, "1..%d\n", len(tests))
+	for i, test := range tests {
+		result, found := results[test.Name]
+		switch {
+		case found && result.Passed:
+			fmt.Fprintf(&b, "ok %d - %s\n", i+1, test.Name)
+		case found:
+			fmt.Fprintf(&b, "not ok %d - %s\n", i+1, test.Name)
+			b.WriteString("  ---\n")
+			fmt.Fprintf(&b, "  message: %q\n", failureMessage(result))
+			fmt.Fprintf(&b, "  endpoint: %s\n", result.Endpoint)
+			if position := test.Position(); position != "" {
+				fmt.Fprintf(&b, "  at: %s\n", position)
+			}
+			fmt.Fprintf(&b, "  expected: %v\n", result.Expected)
+			fmt.Fprintf(&b, "  actual: %s\n", formatActual(result.Actual))
+			b.WriteString("  ...\n")
+		default:
+			if reason, skipped := report.SkipReasons[test.Name]; skipped {
+				fmt.Fprintf(&b, "ok %d - %s # SKIP %s\n", i+1, test.Name, reason)
+			} else {
+				fmt.Fprintf(&b, "not ok %d - %s # %s\n", i+1, test.Name, notRunMessage)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteGithubAnnotations writes an `::error` GitHub Actions workflow command for each of
+// the failed `tests`, pointing at the position of the Test in its Testcase YAML file.
+func WriteGithubAnnotations(w io.Writer, tests []TestUnit, report *TestReport) error {
+	var results = resultsByName(report)
+	var b strings.Builder
+	for _, test := range tests {
+		result, found := results[test.Name]
+		if found && result.Passed {
+			continue
+		}
+		var message string
+		if found {
+			message = failureMessage(result)
+			if result.Explanation != nil {
+				message += "\n" + result.Explanation.String()
+			}
+		} else if _, skipped := report.SkipReasons[test.Name]; skipped {
+			continue
+		} else {
+			message = notRunMessage
+		}
+		var properties []string
+		if test.File != "" {
+			properties = append(properties,
+				"file="+escapeProperty(test.File), fmt.Sprintf("line=%d", test.Line))
+		}
+		properties = append(properties, "title="+escapeProperty(test.Name+" failed"))
+		fmt.Fprintf(&b, "::error %s::%s\n", strings.Join(properties, ","), escapeData(message))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// escapeData escapes the message of a GitHub workflow command.
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes the value of a GitHub workflow command property.
+func escapeProperty(s string) string {
+	return strings.NewReplacer(":", "%3A", ",", "%2C").Replace(escapeData(s))
+}
+
+func resultsByName(report *TestReport) map[string]*TestResult {
+	var results = make(map[string]*TestResult, len(report.Results))
+	for _, result := range report.Results {
+		results[result.Name] = result
+	}
+	return results
+}
@@ -0,0 +1,54 @@
+package testing_test
+
+import (
+	"bytes"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Formats", func() {
+	var tests []TestUnit
+	var report *TestReport
+	var out *bytes.Buffer
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+		tests, report = reportFixture(nil, nil)
+	})
+
+	It("writes TAP in the tests order", func() {
+		Expect(WriteTAP(out, tests, report)).To(Succeed())
+		Expect(out.String()).To(Equal(`TAP version 13
+1..3
+ok 1 - Overrides.default_target
+not ok 2 - Overrides.override_policy
+  ---
+  message: "expected true, got false"
+  endpoint: copilotiq/audit_required
+  at: ../testdata/testcases/overrides.yaml:27
+  expected: true
+  actual: false
+  ...
+ok 3 - Overrides.override_package # SKIP not ready
+`))
+	})
+	It("writes GitHub annotations for the failures", func() {
+		Expect(WriteGithubAnnotations(out, tests, report)).To(Succeed())
+		Expect(out.String()).To(Equal("::error file=../testdata/testcases/overrides.yaml,line=27," +
+			"title=Overrides.override_policy failed::expected true, got false\n"))
+	})
+	It("escapes the GitHub annotations", func() {
+		report.Failures()[0].Explanation = &Explanation{Rule: "data.copilotiq.audit_required", Result: "100%"}
+		tests[1].File = "a,b:c.yaml"
+		Expect(WriteGithubAnnotations(out, tests, report)).To(Succeed())
+		Expect(out.String()).To(Equal("::error file=a%2Cb%3Ac.yaml,line=27," +
+			"title=Overrides.override_policy failed::expected true, got false%0A" +
+			"data.copilotiq.audit_required = 100%25\n"))
+	})
+	It("reports the tests which were not run", func() {
+		report = &TestReport{}
+		Expect(WriteTAP(out, tests[:1], report)).To(Succeed())
+		Expect(out.String()).To(HaveSuffix("not ok 1 - Overrides.default_target # not run\n"))
+	})
+})
@@ -0,0 +1,164 @@
+// Copyright (c) 2022 CopilotIQ Inc.  All rights reserved
+
+package testing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A Golden is the snapshot of a test: the request sent to OPA, and its response (whose
+// `result` is missing, if undefined); Error is set if OPA did not return a result.
+type Golden struct {
+	Name     string       `json:"name"`
+	Endpoint string       `json:"endpoint"`
+	Request  *TestBody    `json:"request"`
+	Response *OpaResponse `json:"response,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// OpaResponse is the body of the OPA server response.
+type OpaResponse struct {
+	Result interface{} `json:"result,omitempty"`
+}
+
+// A GoldenDrift is a test whose request, or response, differs from its Golden file.
+type GoldenDrift struct {
+	Name    string
+	File    string
+	Message string
+}
+
+func (d GoldenDrift) String() string {
+	return fmt.Sprintf("%s: %s (%s)", d.Name, d.Message, d.File)
+}
+
+// unsafeChars are replaced in the tests' names to obtain the Golden file names
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// GoldenFile returns the path of the Golden file for the `test` in the `dir` directory.
+func GoldenFile(dir string, test string) string {
+	return filepath.Join(dir, unsafeChars.ReplaceAllString(test, "_")+".json")
+}
+
+func newGolden(result *TestResult) *Golden {
+	golden := Golden{Name: result.Name, Endpoint: result.Endpoint, Request: result.Body}
+	// The OPA server only returns a result if it is not undefined
+	if result.Error != "" && result.Actual == nil && result.Error != UndefinedResult {
+		golden.Error = result.Error
+	} else {
+		golden.Response = &OpaResponse{Result: result.Actual}
+	}
+	return &golden
+}
+
+// WriteGolden saves a Golden file in the `dir` directory for each of the results in the
+// `report` (the skipped tests have none), and returns the number of files written.
+func WriteGolden(dir string, report *TestReport) (int, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return 0, err
+	}
+	for _, result := range report.Results {
+		data, err := json.MarshalIndent(newGolden(result), "", "  ")
+		if err != nil {
+			return 0, err
+		}
+		if err := os.WriteFile(GoldenFile(dir, result.Name), append(data, '\n'), 0640); err != nil {
+			return 0, err
+		}
+	}
+	return len(report.Results), nil
+}
+
+// StaleGolden returns the paths of the Golden files in the `dir` directory which none of
+// the `tests` has (e.g., those of the tests removed, or renamed), sorted.
+func StaleGolden(dir string, tests []TestUnit) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var current = make(map[string]bool, len(tests))
+	for _, test := range tests {
+		current[GoldenFile(dir, test.Name)] = true
+	}
+	var stale []string
+	for _, file := range files {
+		if !current[file] {
+			stale = append(stale, file)
+		}
+	}
+	sort.Strings(stale)
+	return stale, nil
+}
+
+// CheckGolden compares all the results in the `report` with the Golden files in the `dir`
+// directory, and returns those which drifted (or have no Golden file), in the tests order,
+// followed by the StaleGolden files (as drifts of the tests they were saved for).
+func CheckGolden(dir string, tests []TestUnit, report *TestReport) []GoldenDrift {
+	var results = resultsByName(report)
+	var drifts []GoldenDrift
+	for _, test := range tests {
+		result, found := results[test.Name]
+		if !found {
+			continue
+		}
+		file := GoldenFile(dir, test.Name)
+		drift := func(format string, args ...interface{}) {
+			drifts = append(drifts, GoldenDrift{Name: test.Name, File: file, Message: fmt.Sprintf(format, args...)})
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			drift("no golden file")
+			continue
+		}
+		var expected, actual interface{}
+		if err := json.Unmarshal(data, &expected); err != nil {
+			drift("invalid golden file: %v", err)
+			continue
+		}
+		if actual, err = asJson(newGolden(result)); err != nil {
+			drift("%v", err)
+			continue
+		}
+		golden, current := expected.(map[string]interface{}), actual.(map[string]interface{})
+		for _, field := range []string{"endpoint", "request", "response", "error"} {
+			if !reflect.DeepEqual(golden[field], current[field]) {
+				drift("%s changed from %s to %s", field, toJson(golden[field]), toJson(current[field]))
+			}
+		}
+	}
+	stale, err := StaleGolden(dir, tests)
+	if err != nil {
+		return append(drifts, GoldenDrift{File: dir, Message: err.Error()})
+	}
+	for _, file := range stale {
+		drifts = append(drifts, GoldenDrift{Name: strings.TrimSuffix(filepath.Base(file), ".json"), File: file,
+			Message: "stale golden file, no such test"})
+	}
+	return drifts
+}
+
+// asJson round-trips `v` through JSON, so that it can be compared with decoded JSON.
+func asJson(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+func toJson(v interface{}) string {
+	if v == nil {
+		return "nothing"
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
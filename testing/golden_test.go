@@ -0,0 +1,80 @@
+package testing_test
+
+import (
+	. "github.com/CopilotIQ/opa-tests/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("Golden files", func() {
+	var tests []TestUnit
+	var report *TestReport
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "golden-*")
+		Expect(err).ShouldNot(HaveOccurred())
+		tests, report = reportFixture(nil, func(_ *TestResult, failed *TestResult) {
+			failed.Actual = nil
+			failed.Error = UndefinedResult
+		})
+		count, err := WriteGolden(dir, report)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(count).To(Equal(2))
+	})
+	AfterEach(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	It("saves the request and response of each test", func() {
+		Expect(GoldenFile(dir, "Users.get a/user")).To(Equal(filepath.Join(dir, "Users.get_a_user.json")))
+		data, err := os.ReadFile(GoldenFile(dir, tests[0].Name))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring(`"endpoint": "copilotiq/allow"`))
+		Expect(string(data)).To(ContainSubstring(`"api_token": "` + tests[0].Body.Input.Token))
+		Expect(string(data)).To(ContainSubstring("\"response\": {\n    \"result\": true\n  }"))
+		// An undefined result is an empty response
+		data, err = os.ReadFile(GoldenFile(dir, tests[1].Name))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring(`"response": {}`))
+		_, err = os.Stat(GoldenFile(dir, tests[2].Name))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+	It("finds no drift in the same results", func() {
+		Expect(CheckGolden(dir, tests, report)).To(BeEmpty())
+	})
+	It("detects the drifted results", func() {
+		report.Results[0].Actual = false
+		report.Results[1].Error = "OPA server returned 500 Internal Server Error"
+		report.ReportSuccess(&TestResult{Name: tests[2].Name, Body: &tests[2].Body, Actual: true})
+		drifts := CheckGolden(dir, tests, report)
+		Expect(drifts).To(HaveLen(4))
+		Expect(drifts[0].String()).To(Equal("Overrides.default_target: response changed from " +
+			`{"result":true} to {"result":false} (` + GoldenFile(dir, tests[0].Name) + ")"))
+		Expect(drifts[1].Message).To(Equal("response changed from {} to nothing"))
+		Expect(drifts[2].Message).To(Equal(
+			`error changed from nothing to "OPA server returned 500 Internal Server Error"`))
+		Expect(drifts[3].Message).To(Equal("no golden file"))
+	})
+	It("detects the stale golden files", func() {
+		Expect(os.Rename(GoldenFile(dir, tests[1].Name), GoldenFile(dir, "Overrides.renamed"))).To(Succeed())
+		Expect(StaleGolden(dir, tests)).To(Equal([]string{GoldenFile(dir, "Overrides.renamed")}))
+		drifts := CheckGolden(dir, tests, report)
+		Expect(drifts).To(HaveLen(2))
+		Expect(drifts[0].Message).To(Equal("no golden file"))
+		Expect(drifts[1].String()).To(Equal("Overrides.renamed: stale golden file, no such test (" +
+			GoldenFile(dir, "Overrides.renamed") + ")"))
+	})
+	It("detects the drifted requests", func() {
+		body := tests[0].Body
+		body.Input.Resource.Method = "DELETE"
+		report.Results[0].Body = &body
+		drifts := CheckGolden(dir, tests, report)
+		Expect(drifts).To(HaveLen(1))
+		Expect(drifts[0].Message).To(HavePrefix("request changed from "))
+		Expect(drifts[0].Message).To(ContainSubstring(`"method":"DELETE"`))
+	})
+})
@@ -0,0 +1,178 @@
+// Copyright (c) 2022 CopilotIQ Inc.  All rights reserved
+
+package testing
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"time"
+)
+
+// The status of each of the tests in the HTML report
+const (
+	passedStatus  = "passed"
+	failedStatus  = "failed"
+	skippedStatus = "skipped"
+)
+
+type htmlTest struct {
+	Name        string
+	Status      string
+	Endpoint    string
+	Position    string
+	Expected    bool
+	Response    string
+	Error       string
+	Reason      string
+	Duration    time.Duration
+	Claims      string
+	Input       string
+	Explanation string
+}
+
+type htmlTestcase struct {
+	Name    string
+	File    string
+	Passed  int
+	Failed  int
+	Skipped int
+	Tests   []htmlTest
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Policies Tests Report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.dashboard { display: flex; gap: 1em; margin-bottom: 2em; }
+.card { border: 1px solid #ccc; border-radius: 4px; padding: 0.5em 1.5em; text-align: center; }
+.card .count { font-size: 2em; font-weight: bold; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+pre { background: #f6f6f6; margin: 0.3em 0; padding: 0.5em; white-space: pre-wrap; }
+summary { cursor: pointer; }
+.passed { color: #080; }
+.failed { color: #c00; }
+.skipped { color: #a60; }
+.faint { color: #888; }
+</style>
+</head>
+<body>
+<h1>Policies Tests Report</h1>
+<p class="faint">Generated on {{ .Generated.Format "2006-01-02 15:04:05 MST" }}, took {{ .Elapsed }}{{ with .Report.BundleSHA256 }}, bundle SHA-256: <code>{{ . }}</code>{{ end }}</p>
+<div class="dashboard">
+<div class="card"><div class="count">{{ .Report.Total }}</div>tests</div>
+<div class="card passed"><div class="count">{{ .Report.Succeeded }}</div>passed</div>
+<div class="card failed"><div class="count">{{ .Report.Failed }}</div>failed</div>
+<div class="card skipped"><div class="count">{{ .Report.Skipped }}</div>skipped</div>
+</div>
+<table>
+<tr><th>Testcase</th><th>File</th><th>Passed</th><th>Failed</th><th>Skipped</th></tr>
+{{ range .Testcases }}<tr><td><a href="#{{ .Name }}">{{ .Name }}</a></td><td>{{ .File }}</td><td class="passed">{{ .Passed }}</td><td class="failed">{{ .Failed }}</td><td class="skipped">{{ .Skipped }}</td></tr>
+{{ end }}</table>
+{{ range .Testcases }}
+<h2 id="{{ .Name }}">{{ .Name }}</h2>
+<table>
+<tr><th>Test</th><th>Status</th><th>Endpoint</th><th>Expected</th><th>Response</th><th>Time</th></tr>
+{{ range .Tests }}<tr>
+<td>
+<details>
+<summary>{{ .Name }}</summary>
+{{ if .Position }}<p class="faint">{{ .Position }}</p>{{ end }}
+{{ if .Error }}<p class="failed">{{ .Error }}</p>{{ end }}
+{{ if .Reason }}<p class="skipped">Skipped: {{ .Reason }}</p>{{ end }}
+{{ if .Input }}<p>JWT claims:</p>
+<pre>{{ .Claims }}</pre>
+<p>Input sent to OPA:</p>
+<pre>{{ .Input }}</pre>{{ end }}
+{{ if .Explanation }}<p>Explanation:</p>
+<pre>{{ .Explanation }}</pre>{{ end }}
+</details>
+</td>
+<td class="{{ .Status }}">{{ .Status }}</td>
+<td>{{ .Endpoint }}</td>
+<td>{{ .Expected }}</td>
+<td>{{ .Response }}</td>
+<td>{{ if .Duration }}{{ .Duration }}{{ end }}</td>
+</tr>
+{{ end }}</table>
+{{ end }}
+</body>
+</html>
+`))
+
+// WriteHTML writes a self-contained HTML page, with a summary of the results and, for
+// each Testcase, the details of its tests: the decoded JWT claims, the input sent to OPA,
+// the response and the time it took.
+func WriteHTML(w io.Writer, tests []TestUnit, report *TestReport, elapsed time.Duration) error {
+	var results = resultsByName(report)
+	var testcases []htmlTestcase
+	for _, group := range groupByTestcase(tests) {
+		testcase := htmlTestcase{Name: group.Name}
+		for _, test := range group.Tests {
+			if testcase.File == "" {
+				testcase.File = test.File
+			}
+			t := htmlTest{
+				Name:     test.Name,
+				Endpoint: test.Endpoint,
+				Position: test.Position(),
+				Expected: test.Expectation,
+			}
+			// The Rego tests have no request
+			if test.Body.Input.Token != "" {
+				t.Input = indentJson(test.Body)
+				if claims, err := DecodeClaims(test.Body.Input.Token); err == nil {
+					t.Claims = indentJson(claims)
+				} else {
+					t.Claims = err.Error()
+				}
+			}
+			result, found := results[test.Name]
+			reason, skipped := report.SkipReasons[test.Name]
+			switch {
+			case found:
+				t.Response = formatActual(result.Actual)
+				t.Error = result.Error
+				t.Duration = result.Duration.Round(time.Microsecond)
+				if result.Explanation != nil {
+					t.Explanation = result.Explanation.String()
+				}
+				if result.Passed {
+					t.Status = passedStatus
+					testcase.Passed++
+				} else {
+					t.Status = failedStatus
+					testcase.Failed++
+				}
+			case skipped:
+				t.Status = skippedStatus
+				t.Reason = reason
+				testcase.Skipped++
+			default:
+				t.Status = failedStatus
+				t.Error = notRunMessage
+				testcase.Failed++
+			}
+			testcase.Tests = append(testcase.Tests, t)
+		}
+		testcases = append(testcases, testcase)
+	}
+	return reportTemplate.Execute(w, struct {
+		Generated time.Time
+		Elapsed   time.Duration
+		Report    *TestReport
+		Testcases []htmlTestcase
+	}{time.Now(), elapsed.Round(time.Millisecond), report, testcases})
+}
+
+func indentJson(v interface{}) string {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
@@ -0,0 +1,56 @@
+package testing_test
+
+import (
+	"bytes"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("HTML report", func() {
+	var tests []TestUnit
+	var report *TestReport
+	var out *bytes.Buffer
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+		tests, report = reportFixture(nil, func(passed *TestResult, failed *TestResult) {
+			passed.Duration = 3 * time.Millisecond
+			failed.Explanation = &Explanation{Rule: "data.copilotiq.audit_required", Result: false}
+		})
+		report.BundleSHA256 = "c0ffee"
+		Expect(WriteHTML(out, tests, report, time.Second)).To(Succeed())
+	})
+
+	It("shows the summary dashboard", func() {
+		Expect(out.String()).To(ContainSubstring(`<div class="count">3</div>tests`))
+		Expect(out.String()).To(ContainSubstring(
+			`<td><a href="#Overrides">Overrides</a></td><td>../testdata/testcases/overrides.yaml</td>` +
+				`<td class="passed">1</td><td class="failed">1</td><td class="skipped">1</td>`))
+		Expect(out.String()).To(ContainSubstring("bundle SHA-256: <code>c0ffee</code>"))
+	})
+	It("shows the details of each test", func() {
+		Expect(out.String()).To(ContainSubstring("<summary>Overrides.default_target</summary>"))
+		Expect(out.String()).To(ContainSubstring("<td>3ms</td>"))
+		Expect(out.String()).To(ContainSubstring("Skipped: not ready"))
+		Expect(out.String()).To(ContainSubstring("data.copilotiq.audit_required = false"))
+		// The JWT claims are decoded, and the input is the exact JSON sent to OPA
+		Expect(out.String()).To(ContainSubstring("&#34;sub&#34;: &#34;admin@copilotiq.com&#34;"))
+		Expect(out.String()).To(ContainSubstring("&#34;api_token&#34;: &#34;" + tests[0].Body.Input.Token))
+	})
+})
+
+var _ = Describe("JWT claims", func() {
+	It("can be decoded", func() {
+		token := NewToken(&JwtBody{Subject: "me", Roles: []string{"USER"}, Issuer: "test",
+			Claims: map[string]interface{}{"extra": "value"}})
+		claims, err := DecodeClaims(token)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(claims["sub"]).To(Equal("me"))
+		Expect(claims["roles"]).To(Equal([]interface{}{"USER"}))
+		Expect(claims["extra"]).To(Equal("value"))
+		_, err = DecodeClaims("not a token")
+		Expect(err).To(HaveOccurred())
+	})
+})
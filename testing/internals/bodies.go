@@ -0,0 +1,114 @@
+package internals
+
+import (
+	"context"
+	"fmt"
+	"github.com/CopilotIQ/opa-tests/testing"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/topdown"
+	"sort"
+)
+
+// A RuleBody is one of the definitions of a rule targeted by the tests (e.g., one
+// of the several `allow { ... }` blocks in a module); Tests are those tests, expected
+// to be true, for which this body evaluated to true and thus decided their result.
+type RuleBody struct {
+	Rule  string
+	File  string
+	Line  int
+	Tests []string
+}
+
+func (b RuleBody) String() string {
+	return fmt.Sprintf("%s:%d: %s", b.File, b.Line, b.Rule)
+}
+
+// RuleBodiesReport lists all the bodies of the rules targeted by the tests.
+type RuleBodiesReport struct {
+	Bodies []RuleBody
+}
+
+// Uncovered returns the bodies which never decided any of the tests expected to be true:
+// these are either dead code, or over-permissive rules that no test is asserting.
+func (r *RuleBodiesReport) Uncovered() []RuleBody {
+	var result []RuleBody
+	for _, body := range r.Bodies {
+		if len(body.Tests) == 0 {
+			result = append(result, body)
+		}
+	}
+	return result
+}
+
+// exitTracer records the location of all the rules whose body was successfully evaluated.
+type exitTracer struct {
+	exits map[string]bool
+}
+
+func (t *exitTracer) Enabled() bool {
+	return true
+}
+
+func (t *exitTracer) Config() topdown.TraceConfig {
+	return topdown.TraceConfig{}
+}
+
+func (t *exitTracer) TraceEvent(event topdown.Event) {
+	if event.Op != topdown.ExitOp {
+		return
+	}
+	if rule, ok := event.Node.(*ast.Rule); ok && rule.Location != nil {
+		t.exits[rule.Location.String()] = true
+	}
+}
+
+// CollectRuleBodies evaluates all the `tests` (except the skipped ones) with the embedded
+// OPA engine, and reports which bodies of the targeted rules decided the tests expected
+// to be true.
+func CollectRuleBodies(ctx context.Context, policies *Policies, tests []testing.TestUnit) (*RuleBodiesReport, error) {
+	var bodies = make(map[string]*RuleBody)
+	var endpoints = make(map[string]bool)
+	for _, test := range testing.Focus(tests) {
+		if test.Skip != "" {
+			continue
+		}
+		if !endpoints[test.Endpoint] {
+			endpoints[test.Endpoint] = true
+			for _, rule := range policies.Compiler.GetRulesExact(EndpointRef(test.Endpoint)) {
+				if rule.Default || len(rule.Head.Args) > 0 {
+					continue
+				}
+				bodies[rule.Location.String()] = &RuleBody{
+					Rule: rule.Ref().String(),
+					File: rule.Location.File,
+					Line: rule.Location.Row,
+				}
+			}
+		}
+		if !test.Expectation {
+			continue
+		}
+		tracer := &exitTracer{exits: make(map[string]bool)}
+		_, err := policies.Evaluate(ctx, test.Endpoint, test.Body.Input, rego.EvalQueryTracer(tracer))
+		if err != nil {
+			return nil, fmt.Errorf("cannot evaluate %s: %v", test.Name, err)
+		}
+		for location := range tracer.exits {
+			if body, found := bodies[location]; found {
+				body.Tests = append(body.Tests, test.Name)
+			}
+		}
+	}
+	var report RuleBodiesReport
+	for _, body := range bodies {
+		report.Bodies = append(report.Bodies, *body)
+	}
+	sort.Slice(report.Bodies, func(i, j int) bool {
+		if report.Bodies[i].File == report.Bodies[j].File {
+			return report.Bodies[i].Line < report.Bodies[j].Line
+		}
+		return report.Bodies[i].File < report.Bodies[j].File
+	})
+	return &report, nil
+}
@@ -0,0 +1,51 @@
+package internals_test
+
+import (
+	"context"
+	"github.com/CopilotIQ/opa-tests/testing"
+	"github.com/CopilotIQ/opa-tests/testing/internals"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rule bodies", func() {
+	var report *internals.RuleBodiesReport
+	BeforeEach(func() {
+		policies, err := internals.LoadPolicies(examplePolicies)
+		Expect(err).ShouldNot(HaveOccurred())
+		tests, err := testing.Generate(exampleTests)
+		Expect(err).ShouldNot(HaveOccurred())
+		report, err = internals.CollectRuleBodies(context.Background(), policies, tests)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("lists all the bodies of the targeted rules", func() {
+		// The default rule is not a body, and is excluded
+		Expect(report.Bodies).To(HaveLen(12))
+		for _, body := range report.Bodies {
+			Expect(body.Rule).To(Equal("data.copilotiq.allow"))
+		}
+	})
+	It("reports the tests decided by each body", func() {
+		users := filepath.Join(examplePolicies, "users.rego")
+		var adminBody internals.RuleBody
+		for _, body := range report.Bodies {
+			if body.File == users && body.Line == 28 {
+				adminBody = body
+			}
+		}
+		Expect(adminBody.Tests).To(ConsistOf(
+			"Users.admin_create_user", "Users.admin_delete_user", "Users.admin_get_user"))
+	})
+	It("flags the bodies which never decide a test", func() {
+		uncovered := report.Uncovered()
+		Expect(uncovered).ToNot(BeEmpty())
+		for _, body := range uncovered {
+			Expect(body.Tests).To(BeEmpty())
+			Expect(body.String()).To(HaveSuffix(": data.copilotiq.allow"))
+		}
+		Expect(len(uncovered)).To(BeNumerically("<", len(report.Bodies)))
+	})
+})
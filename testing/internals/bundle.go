@@ -3,21 +3,45 @@ package internals
 import (
 	"archive/tar"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/CopilotIQ/opa-tests/testing"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"time"
 )
 
 var (
 	log = testing.Log
+
+	// bundleModTime is the modification time of all the files in the bundles, so that the
+	// same sources always produce the same bundle.
+	bundleModTime = time.Unix(0, 0).UTC()
 )
 
+// bundleFileMode is the mode of all the files in the bundles.
+const bundleFileMode = 0644
+
 // CreateBundle takes a JSON Manifest and the path to a directory containing OPA Policies (
 // Rego files) and then generates an archive (`.tar.gz`) file according to OPA Bundle rules.
 // It returns the full path to the temporary file.
+//
+// Bundles are reproducible: the files are sorted by name, and their headers (as well as
+// the gzip one) do not depend on when, or by whom, the sources were written, so that the
+// same sources always produce a byte-identical bundle (see BundleSHA256).
 func CreateBundle(manifestPath string, srcDir string) (string, error) {
+	return CreateSignedBundle(manifestPath, srcDir, nil)
+}
+
+// CreateSignedBundle is the same as CreateBundle, but also adds the SignaturesFile with the
+// hashes of all the bundle's files, signed as configured by `signing` (if not nil).
+//
+// Note that the ES and PS signatures are randomized, so that the bundles they sign are
+// not reproducible.
+func CreateSignedBundle(manifestPath string, srcDir string, signing *BundleSigning) (string, error) {
 	var manifest = testing.ReadManifest(manifestPath)
 	if manifest == nil {
 		return "", fmt.Errorf("cannot load manifest %s", manifestPath)
@@ -30,7 +54,7 @@ func CreateBundle(manifestPath string, srcDir string) (string, error) {
 	defer gzFile.Close()
 	log.Debug("generating bundle to %s", gzFile.Name())
 
-	// gzip writer
+	// gzip writer: its header has neither a name, nor a modification time
 	gzWriter := gzip.NewWriter(gzFile)
 	defer gzWriter.Close()
 
@@ -38,15 +62,10 @@ func CreateBundle(manifestPath string, srcDir string) (string, error) {
 	tarWriter := tar.NewWriter(gzWriter)
 	defer tarWriter.Close()
 
-	// TODO: walk the subtree (instead of just the directory) and modify the test names to
-	// 		 reflect the position in the subtree using WalkDir(root string, fn fs.WalkDirFunc)
-	files, err := filepath.Glob(filepath.Join(srcDir, testing.PoliciesGlob))
+	files, err := policyFiles(srcDir)
 	if err != nil {
 		return "", err
 	}
-	if len(files) == 0 {
-		return "", fmt.Errorf("empty policies directory %s", srcDir)
-	}
 	log.Debug("found Rego files: %s", files)
 
 	// Adding the .manifest from the Manifest file
@@ -55,6 +74,22 @@ func CreateBundle(manifestPath string, srcDir string) (string, error) {
 		return "", fmt.Errorf("could not create temporary .manifest: %v", err)
 	}
 	files = append(files, tmpManifest)
+	if signing != nil {
+		signatures, err := signing.Signatures(files)
+		if err != nil {
+			return "", err
+		}
+		tmpDir, err := os.MkdirTemp("", "signatures-")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(tmpDir)
+		signaturesFile := filepath.Join(tmpDir, SignaturesFile)
+		if err := os.WriteFile(signaturesFile, signatures, 0640); err != nil {
+			return "", err
+		}
+		files = append(files, signaturesFile)
+	}
 	err = tarFiles(files, tarWriter)
 	if err != nil {
 		return "", fmt.Errorf("could not create tarball from Rego files: %v", err)
@@ -76,6 +111,7 @@ func createTempManifest(manifestPath string) (string, error) {
 	return destFile.Name(), nil
 }
 
+// tarFiles writes the `files` to the `tarWriter`, sorted by name, with normalized headers.
 func tarFiles(files []string, tarWriter *tar.Writer) error {
 	// To avoid resource leaks, defer is called outside the for loop.
 	var filesToClose = make([]*os.File, 0)
@@ -88,23 +124,30 @@ func tarFiles(files []string, tarWriter *tar.Writer) error {
 		}
 	}()
 
-	for _, file := range files {
+	sorted := append([]string(nil), files...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return filepath.Base(sorted[i]) < filepath.Base(sorted[j])
+	})
+	for _, file := range sorted {
 		// open file
 		f, err := os.Open(file)
 		if err != nil {
 			return err
 		}
+		filesToClose = append(filesToClose, f)
 		info, err := f.Stat()
 		if err != nil {
 			return err
 		}
 
-		// write file to tar
-		header, err := tar.FileInfoHeader(info, "")
-		if err != nil {
-			return err
+		// write file to tar, with neither the owner, nor the modification time, of the file
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     filepath.Base(file),
+			Size:     info.Size(),
+			Mode:     bundleFileMode,
+			ModTime:  bundleModTime,
 		}
-		header.Name = filepath.Base(file)
 		if err := tarWriter.WriteHeader(header); err != nil {
 			return err
 		}
@@ -114,3 +157,17 @@ func tarFiles(files []string, tarWriter *tar.Writer) error {
 	}
 	return nil
 }
+
+// BundleSHA256 returns the (hex-encoded) SHA-256 digest of the bundle at `bundlePath`.
+func BundleSHA256(bundlePath string) (string, error) {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,228 @@
+package internals
+
+import (
+	"context"
+	"fmt"
+	"github.com/CopilotIQ/opa-tests/testing"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/cover"
+	"github.com/open-policy-agent/opa/rego"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// RuleCoverage is the coverage of a single rule definition (e.g., one of the
+// several `allow` bodies in a module), spanning from Line to EndLine.
+type RuleCoverage struct {
+	Rule       string
+	Line       int
+	EndLine    int
+	Covered    int
+	NotCovered int
+	Coverage   float64
+}
+
+// FileCoverage reports the lines of a Rego module which were (or were not)
+// evaluated by the tests, and the coverage of each of its rules.
+type FileCoverage struct {
+	File       string
+	Coverage   float64
+	Covered    []int
+	NotCovered []int
+	Rules      []RuleCoverage
+}
+
+// CoverageReport is the policies' coverage, collected while evaluating the tests.
+type CoverageReport struct {
+	Coverage float64
+	Files    []FileCoverage
+}
+
+// CollectCoverage evaluates all the `tests` (except the skipped ones) with the embedded
+// OPA engine, and reports which lines and rules of the Policies were evaluated.
+func CollectCoverage(ctx context.Context, policies *Policies, tests []testing.TestUnit) (*CoverageReport, error) {
+	tracer := cover.New()
+	for _, test := range testing.Focus(tests) {
+		if test.Skip != "" {
+			continue
+		}
+		_, err := policies.Evaluate(ctx, test.Endpoint, test.Body.Input, rego.EvalQueryTracer(tracer))
+		if err != nil {
+			return nil, fmt.Errorf("cannot evaluate %s: %v", test.Name, err)
+		}
+	}
+	report := tracer.Report(policies.Modules)
+	var result = CoverageReport{Coverage: report.Coverage}
+	for file, module := range policies.Modules {
+		fileReport, found := report.Files[file]
+		if !found {
+			fileReport = &cover.FileReport{}
+		}
+		result.Files = append(result.Files, fileCoverage(file, module, fileReport))
+	}
+	sort.Slice(result.Files, func(i, j int) bool {
+		return result.Files[i].File < result.Files[j].File
+	})
+	return &result, nil
+}
+
+func fileCoverage(file string, module *ast.Module, report *cover.FileReport) FileCoverage {
+	var result = FileCoverage{
+		File:       file,
+		Coverage:   report.Coverage,
+		Covered:    rangesToLines(report.Covered),
+		NotCovered: rangesToLines(report.NotCovered),
+	}
+	for _, rule := range module.Rules {
+		rc := RuleCoverage{
+			Rule:    rule.Ref().String(),
+			Line:    rule.Location.Row,
+			EndLine: rule.Location.Row + strings.Count(string(rule.Location.Text), "\n"),
+		}
+		for row := rc.Line; row <= rc.EndLine; row++ {
+			if report.IsCovered(row) {
+				rc.Covered++
+			} else if report.IsNotCovered(row) {
+				rc.NotCovered++
+			}
+		}
+		if total := rc.Covered + rc.NotCovered; total > 0 {
+			rc.Coverage = 100.0 * float64(rc.Covered) / float64(total)
+		}
+		result.Rules = append(result.Rules, rc)
+	}
+	return result
+}
+
+func rangesToLines(ranges []cover.Range) []int {
+	var lines []int
+	for _, r := range ranges {
+		for row := r.Start.Row; row <= r.End.Row; row++ {
+			lines = append(lines, row)
+		}
+	}
+	return lines
+}
+
+// WriteLCOV writes the report in the LCOV tracefile format, with each rule
+// definition reported as a "function".
+func (r *CoverageReport) WriteLCOV(w io.Writer) error {
+	for _, file := range r.Files {
+		var b strings.Builder
+		b.WriteString("TN:\n")
+		fmt.Fprintf(&b, "SF:%s\n", file.File)
+		var hit int
+		for _, rule := range file.Rules {
+			fmt.Fprintf(&b, "FN:%d,%s:%d\n", rule.Line, rule.Rule, rule.Line)
+		}
+		for _, rule := range file.Rules {
+			var count int
+			if rule.Covered > 0 {
+				count = 1
+				hit++
+			}
+			fmt.Fprintf(&b, "FNDA:%d,%s:%d\n", count, rule.Rule, rule.Line)
+		}
+		fmt.Fprintf(&b, "FNF:%d\nFNH:%d\n", len(file.Rules), hit)
+
+		var lines = make(map[int]int)
+		for _, row := range file.Covered {
+			lines[row] = 1
+		}
+		for _, row := range file.NotCovered {
+			lines[row] = 0
+		}
+		var rows = make([]int, 0, len(lines))
+		for row := range lines {
+			rows = append(rows, row)
+		}
+		sort.Ints(rows)
+		for _, row := range rows {
+			fmt.Fprintf(&b, "DA:%d,%d\n", row, lines[row])
+		}
+		fmt.Fprintf(&b, "LF:%d\nLH:%d\n", len(rows), len(file.Covered))
+		b.WriteString("end_of_record\n")
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type htmlLine struct {
+	Number int
+	Text   string
+	Class  string
+}
+
+type htmlFile struct {
+	FileCoverage
+	Lines []htmlLine
+}
+
+var coverageTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Policies Coverage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table.summary td, table.summary th { padding: 0.2em 1em; text-align: left; }
+pre { margin: 0; }
+.source { border-collapse: collapse; font-family: monospace; width: 100%; }
+.source td { padding: 0 0.5em; white-space: pre; }
+.source td.line { color: #888; text-align: right; width: 3em; }
+.covered { background: #dfd; }
+.not-covered { background: #fdd; }
+</style>
+</head>
+<body>
+<h1>Policies Coverage: {{ printf "%.1f" .Coverage }}%</h1>
+<table class="summary">
+<tr><th>File</th><th>Coverage</th></tr>
+{{ range .Files }}<tr><td><a href="#{{ .File }}">{{ .File }}</a></td><td>{{ printf "%.1f" .Coverage }}%</td></tr>
+{{ end }}</table>
+{{ range .Files }}
+<h2 id="{{ .File }}">{{ .File }} ({{ printf "%.1f" .Coverage }}%)</h2>
+<table class="summary">
+<tr><th>Rule</th><th>Lines</th><th>Coverage</th></tr>
+{{ range .Rules }}<tr><td>{{ .Rule }}</td><td>{{ .Line }}-{{ .EndLine }}</td><td>{{ printf "%.1f" .Coverage }}%</td></tr>
+{{ end }}</table>
+<table class="source">
+{{ range .Lines }}<tr class="{{ .Class }}"><td class="line">{{ .Number }}</td><td>{{ .Text }}</td></tr>
+{{ end }}</table>
+{{ end }}
+</body>
+</html>
+`))
+
+// WriteHTML writes a self-contained HTML page, showing the coverage summary and
+// the source of each of the policies, with the (not) covered lines highlighted.
+func (r *CoverageReport) WriteHTML(w io.Writer) error {
+	var files []htmlFile
+	for _, file := range r.Files {
+		source, err := os.ReadFile(file.File)
+		if err != nil {
+			return err
+		}
+		var classes = make(map[int]string)
+		for _, row := range file.Covered {
+			classes[row] = "covered"
+		}
+		for _, row := range file.NotCovered {
+			classes[row] = "not-covered"
+		}
+		var lines []htmlLine
+		for i, text := range strings.Split(string(source), "\n") {
+			lines = append(lines, htmlLine{Number: i + 1, Text: text, Class: classes[i+1]})
+		}
+		files = append(files, htmlFile{FileCoverage: file, Lines: lines})
+	}
+	return coverageTemplate.Execute(w, struct {
+		Coverage float64
+		Files    []htmlFile
+	}{r.Coverage, files})
+}
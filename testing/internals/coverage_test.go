@@ -0,0 +1,64 @@
+package internals_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/CopilotIQ/opa-tests/testing"
+	"github.com/CopilotIQ/opa-tests/testing/internals"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	exampleTests = "../../examples/tests"
+)
+
+var _ = Describe("Coverage", func() {
+	var report *internals.CoverageReport
+	BeforeEach(func() {
+		policies, err := internals.LoadPolicies(examplePolicies)
+		Expect(err).ShouldNot(HaveOccurred())
+		tests, err := testing.Generate(exampleTests)
+		Expect(err).ShouldNot(HaveOccurred())
+		report, err = internals.CollectCoverage(context.Background(), policies, tests)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("reports all the files", func() {
+		Expect(report.Files).To(HaveLen(3))
+		Expect(report.Coverage).To(BeNumerically(">", 50))
+		Expect(report.Coverage).To(BeNumerically("<", 100))
+	})
+	It("reports the coverage of each rule", func() {
+		common := report.Files[0]
+		Expect(common.File).To(Equal(filepath.Join(examplePolicies, "common.rego")))
+		var found bool
+		for _, rule := range common.Rules {
+			switch rule.Rule {
+			case "data.copilotiq.common.is_admin":
+				Expect(rule.Coverage).To(Equal(100.0))
+			case "data.copilotiq.common.is_manager":
+				found = true
+				Expect(rule.Line).To(Equal(46))
+				Expect(rule.EndLine).To(Equal(49))
+				Expect(rule.Covered).To(Equal(0))
+			}
+		}
+		Expect(found).To(BeTrue())
+	})
+	It("can be written as LCOV", func() {
+		var b bytes.Buffer
+		Expect(report.WriteLCOV(&b)).To(Succeed())
+		Expect(b.String()).To(ContainSubstring("SF:" + filepath.Join(examplePolicies, "common.rego")))
+		Expect(b.String()).To(ContainSubstring("FNDA:0,data.copilotiq.common.is_manager:46\n"))
+		Expect(b.String()).To(ContainSubstring("DA:46,0\n"))
+		Expect(b.String()).To(ContainSubstring("end_of_record\n"))
+	})
+	It("can be written as HTML", func() {
+		var b bytes.Buffer
+		Expect(report.WriteHTML(&b)).To(Succeed())
+		Expect(b.String()).To(ContainSubstring(`<tr class="not-covered"><td class="line">46</td>`))
+	})
+})
@@ -0,0 +1,47 @@
+package internals
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	. "github.com/CopilotIQ/opa-tests/testing"
+)
+
+// DecisionLogsFlags are the `opa run` flags which enable the decision logs on the console,
+// and have the decision ID returned with each result.
+var DecisionLogsFlags = []string{"--set", "decision_logs.console=true"}
+
+// DecisionLogs returns the decision log entries in the OPA server `logs` (one JSON
+// object per line), by decision ID; all the other lines are ignored.
+func DecisionLogs(logs []byte) map[string]map[string]interface{} {
+	var entries = make(map[string]map[string]interface{})
+	scanner := bufio.NewScanner(bytes.NewReader(logs))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if id, ok := entry["decision_id"].(string); ok && id != "" {
+			entries[id] = entry
+		}
+	}
+	return entries
+}
+
+// AttachDecisionLogs attaches to each of the failed results in the `report` the entry
+// for its decision in the OPA server `logs`, and returns how many were attached.
+func AttachDecisionLogs(logs []byte, report *TestReport) int {
+	entries := DecisionLogs(logs)
+	var attached int
+	for _, result := range report.Results {
+		if result.Passed || result.DecisionID == "" {
+			continue
+		}
+		if entry, found := entries[result.DecisionID]; found {
+			result.DecisionLog = entry
+			attached++
+		}
+	}
+	return attached
+}
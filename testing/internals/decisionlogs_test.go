@@ -0,0 +1,51 @@
+package internals_test
+
+import (
+	"encoding/json"
+	"github.com/CopilotIQ/opa-tests/testing"
+	"github.com/CopilotIQ/opa-tests/testing/internals"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const opaLogs = `{"level":"info","msg":"Initializing server.","time":"2022-06-22T10:00:00Z"}
+{"client_addr":"172.17.0.1:50112","level":"info","msg":"Received request.","req_id":1,"req_method":"POST"}
+{"decision_id":"d1","input":{"resource":{"path":"/users"}},"labels":{"id":"a1"},"level":"info","msg":"Decision Log","path":"copilotiq/allow","result":false}
+not a JSON line
+{"decision_id":"d2","input":{"resource":{"path":"/tokens"}},"level":"info","msg":"Decision Log","path":"copilotiq/allow","result":true}
+`
+
+var _ = Describe("Decision logs", func() {
+	It("are found in the OPA server logs", func() {
+		entries := internals.DecisionLogs([]byte(opaLogs))
+		Expect(entries).To(HaveLen(2))
+		Expect(entries["d1"]).To(HaveKeyWithValue("path", "copilotiq/allow"))
+	})
+	It("are attached to the failed tests", func() {
+		report := &testing.TestReport{}
+		report.ReportFailure(&testing.TestResult{Name: "Users.fails", DecisionID: "d1"})
+		report.ReportFailure(&testing.TestResult{Name: "Users.unknown", DecisionID: "d3"})
+		report.ReportFailure(&testing.TestResult{Name: "Users.no_decision"})
+		report.ReportSuccess(&testing.TestResult{Name: "Tokens.passes", DecisionID: "d2"})
+		Expect(internals.AttachDecisionLogs([]byte(opaLogs), report)).To(Equal(1))
+		Expect(report.Results[0].DecisionLog).To(HaveKeyWithValue("result", false))
+		Expect(report.Results[1].DecisionLog).To(BeNil())
+		Expect(report.Results[3].DecisionLog).To(BeNil())
+	})
+	It("are correlated via the decision ID returned by OPA", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": false, "decision_id": "d1"})
+		}))
+		defer server.Close()
+		tests, err := testing.Generate("../../testdata/testcases")
+		Expect(err).ShouldNot(HaveOccurred())
+		report := internals.RunTests(tests[:1], 1, strings.TrimPrefix(server.URL, "http://"), nil)
+		Expect(report.Results).To(HaveLen(1))
+		Expect(report.Results[0].DecisionID).To(Equal("d1"))
+		Expect(report.Results[0].Actual).To(Equal(false))
+	})
+})
@@ -0,0 +1,244 @@
+package internals
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/CopilotIQ/opa-tests/testing"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// A Decision is an `input` to be evaluated against the rule at Endpoint; the Name
+// identifies where it came from (a test, or a line in a corpus file).
+//
+// Decisions read from OPA decision logs also carry their ID and the logged Result.
+type Decision struct {
+	Name     string
+	ID       string `json:",omitempty"`
+	Endpoint string
+	Input    interface{}
+	Result   interface{} `json:",omitempty"`
+}
+
+// A DecisionChange is a Decision whose result differs when evaluated against two
+// different versions of the policies; Error is set if either evaluation failed.
+type DecisionChange struct {
+	Decision
+	From  interface{}
+	To    interface{}
+	Error string `json:",omitempty"`
+}
+
+func (c DecisionChange) String() string {
+	if c.Error != "" {
+		return fmt.Sprintf("%s (%s): %s", c.Name, c.Endpoint, c.Error)
+	}
+	return fmt.Sprintf("%s (%s): %s -> %s", c.Name, c.Endpoint, formatResult(c.From), formatResult(c.To))
+}
+
+func formatResult(result interface{}) string {
+	if result == nil {
+		return "undefined"
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Sprintf("%v", result)
+	}
+	return string(data)
+}
+
+// DecisionsFromTests returns the Decisions for all the `tests`, including the skipped ones.
+func DecisionsFromTests(tests []testing.TestUnit) []Decision {
+	var decisions = make([]Decision, len(tests))
+	for i, test := range tests {
+		decisions[i] = Decision{Name: test.Name, Endpoint: test.Endpoint, Input: test.Body.Input}
+	}
+	return decisions
+}
+
+// corpusEntry is a line in a corpus file: the `path` is the endpoint of the rule
+// (e.g., `copilotiq/allow`); OPA decision logs have the same format, with
+// the addition of the `result` and the `decision_id`.
+type corpusEntry struct {
+	Path       string      `json:"path"`
+	Input      interface{} `json:"input"`
+	Result     interface{} `json:"result"`
+	DecisionID string      `json:"decision_id"`
+}
+
+// ReadCorpus reads the Decisions from a JSONL file (e.g., an OPA decision log), where
+// each line is an object with the `path` of the rule, and the `input` to evaluate;
+// they are named `file:line`.
+//
+// The objects without a `path` (e.g., the other entries logged by OPA along with the
+// decisions) are skipped, but it fails if none of them is a decision.
+func ReadCorpus(path string) ([]Decision, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var decisions []Decision
+	var skipped int
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var entry corpusEntry
+		if err := json.Unmarshal([]byte(text), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if entry.Path == "" {
+			skipped++
+			continue
+		}
+		decisions = append(decisions, Decision{
+			Name:     fmt.Sprintf("%s:%d", path, line),
+			ID:       entry.DecisionID,
+			Endpoint: strings.Trim(entry.Path, UrlSep),
+			Input:    entry.Input,
+			Result:   entry.Result,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(decisions) == 0 {
+		return nil, fmt.Errorf("no decisions in %s (%d entries without a `path`)", path, skipped)
+	}
+	if skipped > 0 {
+		log.Warn("skipped %d entries without a `path` in %s", skipped, path)
+	}
+	return decisions, nil
+}
+
+// DiffDecisions evaluates all the `decisions` against the OPA servers at `fromAddr` and
+// `toAddr`, using `workers` parallel goroutines, and returns those whose result changed,
+// in the same order as the `decisions`.
+func DiffDecisions(fromAddr string, toAddr string, decisions []Decision, workers uint) []DecisionChange {
+	fromURL, toURL := fmt.Sprintf("http://%s", fromAddr), fmt.Sprintf("http://%s", toAddr)
+	var changes = make([]*DecisionChange, len(decisions))
+	forEach(len(decisions), workers, func(i int) {
+		changes[i] = diffDecision(fromURL, toURL, decisions[i])
+	})
+	return collectChanges(changes)
+}
+
+// forEach calls `fn` with all the indexes up to `n`, using `workers` parallel goroutines.
+func forEach(n int, workers uint, fn func(i int)) {
+	if workers == 0 {
+		workers = EstimateWorkers()
+	}
+	var indexes = make(chan int)
+	var wg sync.WaitGroup
+	for i := uint(0); i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				fn(index)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+func collectChanges(changes []*DecisionChange) []DecisionChange {
+	var result []DecisionChange
+	for _, change := range changes {
+		if change != nil {
+			result = append(result, *change)
+		}
+	}
+	return result
+}
+
+// diffDecision returns nil if the `decision` has the same result on both servers.
+func diffDecision(fromURL string, toURL string, decision Decision) *DecisionChange {
+	change := DecisionChange{Decision: decision}
+	var fromErr, toErr error
+	change.From, fromErr = Query(fromURL, decision.Endpoint, decision.Input)
+	change.To, toErr = Query(toURL, decision.Endpoint, decision.Input)
+	switch {
+	case fromErr != nil:
+		change.Error = fmt.Sprintf("cannot evaluate (from): %v", fromErr)
+	case toErr != nil:
+		change.Error = fmt.Sprintf("cannot evaluate (to): %v", toErr)
+	case reflect.DeepEqual(change.From, change.To):
+		return nil
+	}
+	return &change
+}
+
+// CheckoutPolicies writes the Rego files in the `srcDir` directory, as they are at the
+// git `ref` (e.g., a branch, tag or commit), into the `dir` directory.
+func CheckoutPolicies(ref string, srcDir string, dir string) error {
+	path, err := repoPath(srcDir)
+	if err != nil {
+		return err
+	}
+	tree := fmt.Sprintf("%s:%s", ref, path)
+	out, err := exec.Command("git", "ls-tree", "--full-tree", "--name-only", tree).Output()
+	if err != nil {
+		return fmt.Errorf("cannot list %s: %v", tree, commandError(err))
+	}
+	var found int
+	for _, name := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if matched, _ := filepath.Match(testing.PoliciesGlob, name); !matched || isRegoTest(name) {
+			continue
+		}
+		contents, err := exec.Command("git", "show", tree+"/"+name).Output()
+		if err != nil {
+			return fmt.Errorf("cannot read %s/%s: %v", tree, name, commandError(err))
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), contents, 0640); err != nil {
+			return err
+		}
+		found++
+	}
+	if found == 0 {
+		return fmt.Errorf("no policies in %s", tree)
+	}
+	return nil
+}
+
+// repoPath returns the `path` relative to the root of the git repository.
+func repoPath(path string) (string, error) {
+	if filepath.IsAbs(path) {
+		root, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+		if err != nil {
+			return "", commandError(err)
+		}
+		path, err = filepath.Rel(strings.TrimSpace(string(root)), path)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		prefix, err := exec.Command("git", "rev-parse", "--show-prefix").Output()
+		if err != nil {
+			return "", commandError(err)
+		}
+		path = filepath.Join(strings.TrimSpace(string(prefix)), path)
+	}
+	return filepath.ToSlash(filepath.Clean(path)), nil
+}
+
+// commandError adds the error message of the (git, or docker) command to the `err`, if any.
+func commandError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package internals_test
+
+import (
+	"encoding/json"
+	"github.com/CopilotIQ/opa-tests/testing/internals"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testCorpus = "../../testdata/corpus.jsonl"
+
+// fakeOpa returns a server which responds with the `results` by endpoint, and a
+// 500 for all the others.
+func fakeOpa(results map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, found := results[strings.TrimPrefix(r.URL.Path, "/v1/data/")]
+		if !found {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+	}))
+}
+
+var _ = Describe("Diff", func() {
+	It("lists the decisions which changed", func() {
+		from := fakeOpa(map[string]interface{}{"a/allow": true, "a/deny": false,
+			"a/roles": []string{"ADMIN"}, "a/broken": true})
+		defer from.Close()
+		to := fakeOpa(map[string]interface{}{"a/allow": false, "a/deny": false,
+			"a/roles": []string{"ADMIN", "USER"}})
+		defer to.Close()
+		decisions := []internals.Decision{
+			{Name: "allow", Endpoint: "a/allow"},
+			{Name: "deny", Endpoint: "a/deny"},
+			{Name: "roles", Endpoint: "a/roles"},
+			{Name: "broken", Endpoint: "a/broken"},
+		}
+		changes := internals.DiffDecisions(strings.TrimPrefix(from.URL, "http://"),
+			strings.TrimPrefix(to.URL, "http://"), decisions, 2)
+		Expect(changes).To(HaveLen(3))
+		Expect(changes[0].String()).To(Equal("allow (a/allow): true -> false"))
+		Expect(changes[1].String()).To(Equal(`roles (a/roles): ["ADMIN"] -> ["ADMIN","USER"]`))
+		Expect(changes[2].Error).To(ContainSubstring("cannot evaluate (to): OPA server returned 500"))
+	})
+	It("reads the corpus", func() {
+		decisions, err := internals.ReadCorpus(testCorpus)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(decisions).To(HaveLen(2))
+		Expect(decisions[0].Name).To(Equal(testCorpus + ":1"))
+		Expect(decisions[0].Endpoint).To(Equal("copilotiq/allow"))
+		Expect(decisions[1].Name).To(Equal(testCorpus + ":3"))
+		Expect(decisions[1].Endpoint).To(Equal("copilotiq/common/is_admin"))
+	})
+	It("skips the entries which are not decisions", func() {
+		dir, err := os.MkdirTemp("", "corpus-*")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		corpus := filepath.Join(dir, "corpus.jsonl")
+		Expect(os.WriteFile(corpus, []byte(`{"level": "info", "msg": "Initializing server."}`+"\n"), 0600)).
+			To(Succeed())
+		_, err = internals.ReadCorpus(corpus)
+		Expect(err).To(MatchError(ContainSubstring("no decisions in " + corpus)))
+
+		Expect(os.WriteFile(corpus, []byte(`{"level": "info", "msg": "Initializing server."}`+"\n"+
+			`{"path": "copilotiq/allow", "input": {}}`+"\n"+`{"path": `), 0600)).To(Succeed())
+		_, err = internals.ReadCorpus(corpus)
+		Expect(err).To(MatchError(ContainSubstring(corpus + ":3")))
+	})
+	It("checks out the policies at a git ref", func() {
+		dir, err := os.MkdirTemp("", "policies-*")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		Expect(internals.CheckoutPolicies("HEAD", examplePolicies, dir)).To(Succeed())
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		Expect(files).To(ConsistOf(filepath.Join(dir, "common.rego"), filepath.Join(dir, "tokens.rego"),
+			filepath.Join(dir, "users.rego")))
+
+		err = internals.CheckoutPolicies("no-such-ref", examplePolicies, dir)
+		Expect(err).To(MatchError(ContainSubstring("cannot list no-such-ref:examples/policies")))
+	})
+})
@@ -0,0 +1,154 @@
+package internals
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/CopilotIQ/opa-tests/testing"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/topdown"
+)
+
+// Evaluate evaluates the rule at `endpoint` (e.g., `copilotiq/allow`) against the `input`,
+// using the embedded OPA engine with the compiled Policies, instead of the OPA server;
+// the `options` are used to configure the evaluation (e.g., to add tracers).
+//
+// The result is nil if the rule is undefined for the `input`.
+func (p *Policies) Evaluate(ctx context.Context, endpoint string, input interface{},
+	options ...rego.EvalOption) (interface{}, error) {
+	query, err := p.prepare(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	// The input is round-tripped via JSON, so that it is seen by the policies
+	// exactly as it would be by the OPA server.
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	var jsonInput interface{}
+	if err := json.Unmarshal(data, &jsonInput); err != nil {
+		return nil, err
+	}
+	rs, err := query.Eval(ctx, append(options, rego.EvalInput(jsonInput))...)
+	if err != nil {
+		return nil, err
+	}
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return nil, nil
+	}
+	return rs[0].Expressions[0].Value, nil
+}
+
+// prepare returns the (cached) prepared query for the `endpoint`.
+func (p *Policies) prepare(ctx context.Context, endpoint string) (rego.PreparedEvalQuery, error) {
+	if query, found := p.queries.Load(endpoint); found {
+		return query.(rego.PreparedEvalQuery), nil
+	}
+	query, err := rego.New(
+		rego.Compiler(p.Compiler),
+		rego.Query(EndpointRef(endpoint).String()),
+	).PrepareForEval(ctx)
+	if err != nil {
+		return query, err
+	}
+	p.queries.Store(endpoint, query)
+	return query, nil
+}
+
+// explainTracer follows the evaluation of the bodies of a rule, recording whether
+// each succeeded, or which expression first failed.
+type explainTracer struct {
+	bodies  map[string]*testing.BodyTrace
+	queries map[uint64]*testing.BodyTrace
+}
+
+func (t *explainTracer) Enabled() bool {
+	return true
+}
+
+func (t *explainTracer) Config() topdown.TraceConfig {
+	return topdown.TraceConfig{}
+}
+
+func (t *explainTracer) TraceEvent(event topdown.Event) {
+	switch node := event.Node.(type) {
+	case *ast.Rule:
+		if node.Location == nil {
+			return
+		}
+		body, found := t.bodies[node.Location.String()]
+		if !found {
+			return
+		}
+		switch event.Op {
+		case topdown.EnterOp:
+			t.queries[event.QueryID] = body
+		case topdown.ExitOp:
+			body.Succeeded = true
+		}
+	case *ast.Expr:
+		body, found := t.queries[event.QueryID]
+		if found && event.Op == topdown.FailOp && body.FailedExpr == "" && node.Location != nil {
+			// The source text is easier to relate to than the compiled expression.
+			body.FailedExpr = string(node.Location.Text)
+			if body.FailedExpr == "" {
+				body.FailedExpr = node.String()
+			}
+			body.FailedLine = node.Location.Row
+		}
+	}
+}
+
+// Explain evaluates the `test` with the embedded OPA engine, tracing all the
+// bodies of the target rule, to explain why it evaluated to its result.
+//
+// Rule indexing and early exit are disabled, so that all the bodies are evaluated.
+func (p *Policies) Explain(ctx context.Context, test *testing.TestUnit) (*testing.Explanation, error) {
+	ref := EndpointRef(test.Endpoint)
+	explanation := testing.Explanation{Rule: ref.String()}
+	tracer := explainTracer{
+		bodies:  make(map[string]*testing.BodyTrace),
+		queries: make(map[uint64]*testing.BodyTrace),
+	}
+	var bodies []*testing.BodyTrace
+	for _, rule := range p.Compiler.GetRulesExact(ref) {
+		if rule.Default || rule.Location == nil {
+			continue
+		}
+		body := &testing.BodyTrace{Location: rule.Location.String()}
+		tracer.bodies[body.Location] = body
+		bodies = append(bodies, body)
+	}
+	result, err := p.Evaluate(ctx, test.Endpoint, test.Body.Input,
+		rego.EvalQueryTracer(&tracer), rego.EvalRuleIndexing(false), rego.EvalEarlyExit(false))
+	if err != nil {
+		return nil, err
+	}
+	explanation.Result = result
+	for _, body := range bodies {
+		explanation.Bodies = append(explanation.Bodies, *body)
+	}
+	return &explanation, nil
+}
+
+// ExplainFailures adds an Explanation to all the failed tests in the `report`.
+func ExplainFailures(ctx context.Context, policies *Policies, tests []testing.TestUnit,
+	report *testing.TestReport) {
+	var units = make(map[string]*testing.TestUnit, len(tests))
+	for i := range tests {
+		units[tests[i].Name] = &tests[i]
+	}
+	for _, failure := range report.Failures() {
+		test, found := units[failure.Name]
+		if !found {
+			continue
+		}
+		explanation, err := policies.Explain(ctx, test)
+		if err != nil {
+			log.Error("cannot explain %s: %v", test.Name, err)
+			continue
+		}
+		failure.Explanation = explanation
+	}
+}
@@ -0,0 +1,65 @@
+package internals_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/CopilotIQ/opa-tests/testing"
+	"github.com/CopilotIQ/opa-tests/testing/internals"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Embedded engine", func() {
+	var policies *internals.Policies
+	var tests = make(map[string]testing.TestUnit)
+	BeforeEach(func() {
+		var err error
+		policies, err = internals.LoadPolicies(examplePolicies)
+		Expect(err).ShouldNot(HaveOccurred())
+		units, err := testing.Generate(exampleTests)
+		Expect(err).ShouldNot(HaveOccurred())
+		for _, unit := range units {
+			tests[unit.Name] = unit
+		}
+	})
+
+	It("evaluates the tests", func() {
+		test := tests["Users.admin_create_user"]
+		Expect(policies.Evaluate(context.Background(), test.Endpoint, test.Body.Input)).To(BeTrue())
+		test = tests["Users.create_user"]
+		Expect(policies.Evaluate(context.Background(), test.Endpoint, test.Body.Input)).To(BeFalse())
+		// Undefined rules return a nil result
+		Expect(policies.Evaluate(context.Background(), "copilotiq/common/is_admin",
+			test.Body.Input)).To(BeNil())
+	})
+	It("explains the evaluation of all the bodies", func() {
+		test := tests["Users.create_user"]
+		explanation, err := policies.Explain(context.Background(), &test)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(explanation.Rule).To(Equal("data.copilotiq.allow"))
+		Expect(explanation.Result).To(BeFalse())
+		Expect(explanation.Bodies).To(HaveLen(12))
+		users := filepath.Join(examplePolicies, "users.rego")
+		Expect(explanation.Bodies).To(ContainElement(testing.BodyTrace{
+			Location:   fmt.Sprintf("%s:28", users),
+			FailedExpr: "c.is_admin",
+			FailedLine: 30,
+		}))
+	})
+	It("explains the failed tests", func() {
+		var units []testing.TestUnit
+		for _, test := range tests {
+			units = append(units, test)
+		}
+		var report testing.TestReport
+		report.ReportSuccess(&testing.TestResult{Name: "Users.admin_create_user"})
+		report.ReportFailure(&testing.TestResult{Name: "Users.create_user"})
+		internals.ExplainFailures(context.Background(), policies, units, &report)
+		Expect(report.Results[0].Explanation).To(BeNil())
+		Expect(report.Results[1].Explanation).ToNot(BeNil())
+		Expect(report.Results[1].Explanation.String()).To(ContainSubstring(
+			"users.rego:28: failed at line 30: c.is_admin"))
+	})
+})
@@ -0,0 +1,189 @@
+package internals
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/format"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// The supported ExportFormats of the generated requests
+const (
+	CurlFormat  = "curl"
+	HttpFormat  = "http"
+	JsonlFormat = "jsonl"
+	RegoFormat  = "rego"
+)
+
+// ExportFormats are all the formats the generated requests can be exported to; the
+// RegoFormat tests are written to a directory (see WriteRegoTests).
+var ExportFormats = []string{CurlFormat, HttpFormat, JsonlFormat, RegoFormat}
+
+// RegoTestsPackage is the package of the Rego test modules, followed by the Testcase name.
+const RegoTestsPackage = "opatest"
+
+// DefaultOpaUrl is where `opa run --server` listens by default.
+const DefaultOpaUrl = "http://localhost:8181"
+
+// exportEntry is a line of the JSONL export: it has the same format as a corpus
+// file (see ReadCorpus), with the addition of the test's name and expected result.
+type exportEntry struct {
+	Name     string      `json:"name"`
+	Path     string      `json:"path"`
+	Input    interface{} `json:"input"`
+	Expected bool        `json:"expected"`
+}
+
+// Export writes the requests of all the `tests` to `w`, in the given `format`,
+// so that they can be sent to the OPA server at `serverURL` (e.g., DefaultOpaUrl).
+func Export(w io.Writer, tests []TestUnit, format string, serverURL string) error {
+	if !isExportFormat(format) {
+		return fmt.Errorf("invalid export format %s, must be one of: %s",
+			format, strings.Join(ExportFormats, ", "))
+	}
+	var b strings.Builder
+	if format == CurlFormat {
+		b.WriteString("#!/usr/bin/env bash\n")
+	}
+	for _, test := range tests {
+		var data []byte
+		var err error
+		if format == JsonlFormat {
+			data, err = json.Marshal(exportEntry{Name: test.Name, Path: test.Endpoint,
+				Input: test.Body.Input, Expected: test.Expectation})
+		} else {
+			data, err = json.MarshalIndent(test.Body, "", "  ")
+		}
+		if err != nil {
+			return fmt.Errorf("cannot export %s: %v", test.Name, err)
+		}
+		url := fullUrl(serverURL, test.Endpoint)
+		switch format {
+		case CurlFormat:
+			fmt.Fprintf(&b, "\n# %s\n", describeTest(&test))
+			fmt.Fprintf(&b, "curl -s -w '\\n' -X POST %s \\\n  -H 'Content-Type: %s' \\\n  -d %s\n",
+				url, contentType, shellQuote(string(data)))
+		case HttpFormat:
+			fmt.Fprintf(&b, "### %s\n", describeTest(&test))
+			fmt.Fprintf(&b, "POST %s\nContent-Type: %s\n\n%s\n\n", url, contentType, data)
+		case JsonlFormat:
+			b.Write(data)
+			b.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func isExportFormat(format string) bool {
+	for _, f := range ExportFormats {
+		if f == format && f != RegoFormat {
+			return true
+		}
+	}
+	return false
+}
+
+// describeTest returns the name of the `test`, its position and expected result.
+func describeTest(test *TestUnit) string {
+	description := test.Name
+	if position := test.Position(); position != "" {
+		description += " at " + position
+	}
+	return fmt.Sprintf("%s (expected: %v)", description, test.Expectation)
+}
+
+// shellQuote quotes `s` as a single argument for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// nonIdentChars are replaced in the Testcases, and tests, names to obtain Rego identifiers.
+var nonIdentChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// regoIdent returns the Rego identifier for the `name`, prefixed with `_` if it would
+// start with a digit.
+func regoIdent(name string) string {
+	ident := nonIdentChars.ReplaceAllString(name, "_")
+	if ident != "" && ident[0] >= '0' && ident[0] <= '9' {
+		ident = "_" + ident
+	}
+	return ident
+}
+
+// RegoTests returns a Rego test module (to be run with `opa test`) for the Testcase of the
+// `tests`, in the RegoTestsPackage; each test queries its target `with input as` the
+// request body (including the signed JWT), and the skipped tests are marked as `todo_`.
+//
+// As for the HTTP tests, the target must be defined: those expecting false compare it
+// with `false`, instead of negating it (which would also pass if it were undefined).
+//
+// The tests whose names map to the same identifier are numbered (e.g., `test_a_b_2`), as
+// rules with the same name would be OR'ed together.
+func RegoTests(testcase string, tests []TestUnit) ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "package %s.%s\n", RegoTestsPackage, regoIdent(testcase))
+	var idents = make(map[string]bool, len(tests))
+	for _, test := range tests {
+		base := regoIdent(strings.TrimPrefix(test.Name, test.Testcase+"."))
+		ident := base
+		for n := 2; idents[ident]; n++ {
+			ident = fmt.Sprintf("%s_%d", base, n)
+		}
+		idents[ident] = true
+		input, err := ast.InterfaceToValue(test.Body.Input)
+		if err != nil {
+			return nil, fmt.Errorf("cannot export %s: %v", test.Name, err)
+		}
+		var prefix, comparison string
+		if test.Skip != "" {
+			prefix = "todo_"
+		}
+		if !test.Expectation {
+			comparison = " == false"
+		}
+		fmt.Fprintf(&b, "\n# %s\n", describeTest(&test))
+		fmt.Fprintf(&b, "%stest_%s {\n\t%s%s with input as %s\n}\n", prefix, ident,
+			EndpointRef(test.Endpoint), comparison, input)
+	}
+	module, err := format.Source(testcase+"_test.rego", []byte(b.String()))
+	if err != nil {
+		return nil, fmt.Errorf("cannot export %s: %v", testcase, err)
+	}
+	return module, nil
+}
+
+// WriteRegoTests writes the RegoTests module of each of the Testcases of the `tests` as a
+// `<Testcase>_test.rego` file in the `dir` directory, and returns the paths of the files.
+func WriteRegoTests(dir string, tests []TestUnit) ([]string, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	var testcases []string
+	var byTestcase = make(map[string][]TestUnit)
+	for _, test := range tests {
+		if _, found := byTestcase[test.Testcase]; !found {
+			testcases = append(testcases, test.Testcase)
+		}
+		byTestcase[test.Testcase] = append(byTestcase[test.Testcase], test)
+	}
+	var paths []string
+	for _, testcase := range testcases {
+		module, err := RegoTests(testcase, byTestcase[testcase])
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, strings.ToLower(regoIdent(testcase))+"_test.rego")
+		if err := os.WriteFile(path, module, 0640); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
@@ -0,0 +1,125 @@
+package internals_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/CopilotIQ/opa-tests/testing"
+	"github.com/CopilotIQ/opa-tests/testing/internals"
+	"github.com/open-policy-agent/opa/ast"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Export", func() {
+	var tests []testing.TestUnit
+	var out *bytes.Buffer
+	BeforeEach(func() {
+		var err error
+		tests, err = testing.Generate("../../testdata/testcases")
+		Expect(err).ShouldNot(HaveOccurred())
+		out = &bytes.Buffer{}
+	})
+
+	It("exports the requests as JSONL, which can be read as a corpus", func() {
+		Expect(internals.Export(out, tests, internals.JsonlFormat, internals.DefaultOpaUrl)).To(Succeed())
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		Expect(lines).To(HaveLen(len(tests)))
+		var entry map[string]interface{}
+		Expect(json.Unmarshal([]byte(lines[1]), &entry)).To(Succeed())
+		Expect(entry["name"]).To(Equal(tests[1].Name))
+		Expect(entry["path"]).To(Equal("copilotiq/audit_required"))
+
+		dir, err := os.MkdirTemp("", "export")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		corpus := filepath.Join(dir, "corpus.jsonl")
+		Expect(os.WriteFile(corpus, out.Bytes(), 0600)).To(Succeed())
+		decisions, err := internals.ReadCorpus(corpus)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(decisions).To(HaveLen(len(tests)))
+		for i, decision := range decisions {
+			Expect(decision.Endpoint).To(Equal(tests[i].Endpoint))
+			Expect(decision.Input).To(HaveKeyWithValue("api_token", tests[i].Body.Input.Token))
+		}
+	})
+	It("exports the requests as HTTP files", func() {
+		Expect(internals.Export(out, tests[:1], internals.HttpFormat, "http://opa:8181")).To(Succeed())
+		scanner := bufio.NewScanner(out)
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal("### Overrides.default_target at " +
+			"../../testdata/testcases/overrides.yaml:17 (expected: true)"))
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal("POST http://opa:8181/v1/data/copilotiq/allow"))
+	})
+	It("exports the requests as a valid shell script", func() {
+		tests[0].Body.Input.Resource.Path = "/users/o'brien"
+		Expect(internals.Export(out, tests, internals.CurlFormat, internals.DefaultOpaUrl)).To(Succeed())
+		Expect(strings.Count(out.String(), "curl -s")).To(Equal(len(tests)))
+		Expect(out.String()).To(ContainSubstring(`"path": "/users/o'\''brien"`))
+		Expect(exec.Command("bash", "-n", "-c", out.String()).Run()).To(Succeed())
+	})
+	It("rejects unknown formats", func() {
+		Expect(internals.Export(out, tests, "xml", internals.DefaultOpaUrl)).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("Rego tests", func() {
+	var tests []testing.TestUnit
+	var dir string
+	BeforeEach(func() {
+		var err error
+		tests, err = testing.Generate("../../testdata/testcases")
+		Expect(err).ShouldNot(HaveOccurred())
+		dir, err = os.MkdirTemp("", "rego-tests")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("generates a Rego test for each of the tests", func() {
+		tests[2].Skip = "not ready"
+		module, err := internals.RegoTests("Overrides", tests)
+		Expect(err).ShouldNot(HaveOccurred())
+		parsed, err := ast.ParseModule("overrides_test.rego", string(module))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(parsed.Package.Path.String()).To(Equal("data.opatest.Overrides"))
+		Expect(parsed.Rules).To(HaveLen(3))
+		Expect(parsed.Rules[0].Head.Name.String()).To(Equal("test_default_target"))
+		// Fails if the target is undefined, as it does over HTTP
+		Expect(parsed.Rules[1].Body[0].Negated).To(BeFalse())
+		Expect(parsed.Rules[1].Body[0].Operator().String()).To(Equal("equal"))
+		Expect(parsed.Rules[2].Head.Name.String()).To(Equal("todo_test_override_package"))
+	})
+	It("generates valid, and distinct, identifiers", func() {
+		tests[0].Name = "Overrides.a/b"
+		tests[1].Name = "Overrides.a b"
+		module, err := internals.RegoTests("1st Overrides", tests[:2])
+		Expect(err).ShouldNot(HaveOccurred())
+		parsed, err := ast.ParseModule("overrides_test.rego", string(module))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(parsed.Package.Path.String()).To(Equal("data.opatest._1st_Overrides"))
+		Expect(parsed.Rules[0].Head.Name.String()).To(Equal("test_a_b"))
+		Expect(parsed.Rules[1].Head.Name.String()).To(Equal("test_a_b_2"))
+	})
+	It("runs the Rego tests against the policies", func() {
+		paths, err := internals.WriteRegoTests(dir, tests)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(paths).To(Equal([]string{filepath.Join(dir, "overrides_test.rego")}))
+		policies, err := internals.LoadPolicies(examplePolicies)
+		Expect(err).ShouldNot(HaveOccurred())
+		report := &testing.TestReport{}
+		_, err = internals.RunRegoTests(context.Background(), policies, dir, nil, report)
+		Expect(err).ShouldNot(HaveOccurred())
+		// `audit_required` is not defined in the example policies, as for the HTTP tests
+		Expect(report.Succeeded).To(Equal(uint(2)))
+		Expect(report.FailedNames).To(Equal([]string{"opatest.Overrides.test_override_policy"}))
+	})
+})
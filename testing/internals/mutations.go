@@ -0,0 +1,350 @@
+package internals
+
+import (
+	"context"
+	"fmt"
+	"github.com/CopilotIQ/opa-tests/testing"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/format"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A Mutant is a copy of one of the Policies modules, with a single, small change
+// (the mutation) which the tests are expected to detect (and thus "kill" the mutant).
+type Mutant struct {
+	File        string
+	Line        int
+	Description string
+
+	module *ast.Module
+}
+
+func (m *Mutant) String() string {
+	return fmt.Sprintf("%s:%d: %s", m.File, m.Line, m.Description)
+}
+
+const (
+	MutantKilled   = "killed"
+	MutantSurvived = "survived"
+	// MutantInvalid is the status of the Mutants which do not compile (e.g., flipping
+	// an `=` which assigns a variable), and thus cannot be run.
+	MutantInvalid = "invalid"
+)
+
+// MutantResult is the outcome of running the tests against a Mutant: KilledBy lists
+// the tests, passing against the original policies, that failed against the Mutant.
+type MutantResult struct {
+	File        string
+	Line        int
+	Description string
+	Status      string
+	KilledBy    []string `json:",omitempty"`
+	Error       string   `json:",omitempty"`
+}
+
+func (r MutantResult) String() string {
+	return fmt.Sprintf("%s:%d: %s", r.File, r.Line, r.Description)
+}
+
+// MutationReport collects the results of all the Mutants; the Score is the percentage
+// of the (valid) Mutants which were killed by the tests.
+type MutationReport struct {
+	Killed   uint
+	Survived uint
+	Invalid  uint
+	Score    float64
+	Mutants  []MutantResult
+}
+
+// Add records the `result`, and updates the Score.
+func (r *MutationReport) Add(result MutantResult) {
+	switch result.Status {
+	case MutantKilled:
+		r.Killed++
+	case MutantSurvived:
+		r.Survived++
+	default:
+		r.Invalid++
+	}
+	if valid := r.Killed + r.Survived; valid > 0 {
+		r.Score = 100.0 * float64(r.Killed) / float64(valid)
+	}
+	r.Mutants = append(r.Mutants, result)
+}
+
+// Survivors returns the Mutants which none of the tests detected.
+func (r *MutationReport) Survivors() []MutantResult {
+	var result []MutantResult
+	for _, m := range r.Mutants {
+		if m.Status == MutantSurvived {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// swappedLiterals are the replacements for the most common string literals, the
+// HTTP methods; all other strings get a suffix appended.
+var swappedLiterals = map[string]string{
+	"GET":    "POST",
+	"POST":   "GET",
+	"PUT":    "DELETE",
+	"DELETE": "PUT",
+	"PATCH":  "PUT",
+}
+
+// mutation modifies the copy of a module, returning false if it cannot be applied.
+type mutation func(module *ast.Module) bool
+
+// GenerateMutants returns all the Mutants of the Policies, sorted by position:
+//   - flipping `==` (and `=`) to `!=`, and vice versa;
+//   - dropping an expression from a body (unless it's the only one);
+//   - removing the `not` from negated expressions;
+//   - swapping literals (e.g., "GET" to "POST", `true` to `false`, or `1` to `2`);
+//   - removing a whole rule definition (default ones excluded).
+func GenerateMutants(policies *Policies) []*Mutant {
+	var mutants []*Mutant
+	for file, module := range policies.Modules {
+		add := func(loc *ast.Location, description string, mutate mutation) {
+			mutated := module.Copy()
+			if mutate(mutated) {
+				mutants = append(mutants, &Mutant{
+					File:        file,
+					Line:        loc.Row,
+					Description: description,
+					module:      mutated,
+				})
+			}
+		}
+		for r, rule := range module.Rules {
+			r := r
+			if rule.Default {
+				// The body of default rules is always (an implicit) `true`, but their value
+				// is likely to be significant.
+				if value := rule.Head.Value; value != nil && isLiteral(value) {
+					swapped := swapLiteral(value.Value)
+					add(rule.Location, fmt.Sprintf("swapped %v with %v in `default %s`", value, swapped,
+						rule.Head.Ref()), func(m *ast.Module) bool {
+						m.Rules[r].Head.Value = ast.NewTerm(swapped)
+						return true
+					})
+				}
+				continue
+			}
+			add(rule.Location, fmt.Sprintf("removed rule `%s`", rule.Head.Ref()), func(m *ast.Module) bool {
+				m.Rules = append(m.Rules[:r], m.Rules[r+1:]...)
+				return true
+			})
+			for e, expr := range rule.Body {
+				e := e
+				if expr.Generated {
+					continue
+				}
+				text := exprText(expr)
+				if len(rule.Body) > 1 {
+					add(expr.Location, fmt.Sprintf("dropped `%s`", text), func(m *ast.Module) bool {
+						body := m.Rules[r].Body
+						m.Rules[r].Body = append(body[:e], body[e+1:]...)
+						return true
+					})
+				}
+				if expr.Negated {
+					add(expr.Location, fmt.Sprintf("removed `not` from `%s`", text), func(m *ast.Module) bool {
+						m.Rules[r].Body[e].Negated = false
+						return true
+					})
+				}
+				if operator, flipped := flippedOperator(expr); flipped != nil {
+					add(expr.Location, fmt.Sprintf("flipped `%s` to `%s` in `%s`", operator.Infix,
+						flipped.Infix, text), func(m *ast.Module) bool {
+						m.Rules[r].Body[e].Terms.([]*ast.Term)[0] = ast.NewTerm(flipped.Ref())
+						return true
+					})
+				}
+				for t, literal := range literals(expr) {
+					t := t
+					swapped := swapLiteral(literal.Value)
+					add(expr.Location, fmt.Sprintf("swapped %v with %v in `%s`", literal, swapped, text),
+						func(m *ast.Module) bool {
+							literals(m.Rules[r].Body[e])[t].Value = swapped
+							return true
+						})
+				}
+			}
+		}
+	}
+	sort.SliceStable(mutants, func(i, j int) bool {
+		if mutants[i].File == mutants[j].File {
+			return mutants[i].Line < mutants[j].Line
+		}
+		return mutants[i].File < mutants[j].File
+	})
+	return mutants
+}
+
+// WriteSources copies all the Policies modules into the `dir` directory, replacing
+// the mutated one.
+func (m *Mutant) WriteSources(policies *Policies, dir string) error {
+	for file := range policies.Modules {
+		var source []byte
+		var err error
+		if file == m.File {
+			source, err = format.Ast(m.module)
+		} else {
+			source, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(file)), source, 0640); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run runs the `tests` against the Mutant, using a bundle built from its sources (and
+// the manifest) served by a new OPA container; the Mutant is killed by all the tests
+// which passed in the `baseline` report (of the original policies), but fail now.
+//
+// The `ctx` bounds the start of the OPA container.
+func (m *Mutant) Run(ctx context.Context, policies *Policies, manifest string, tests []testing.TestUnit,
+	workers uint, baseline *testing.TestReport) MutantResult {
+	result := MutantResult{File: m.File, Line: m.Line, Description: m.Description, Status: MutantInvalid}
+	dir, err := os.MkdirTemp("", "mutant-*")
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer os.RemoveAll(dir)
+	if err = m.WriteSources(policies, dir); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if _, err = LoadPolicies(dir); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	bundle, err := CreateBundle(manifest, dir)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer os.Remove(bundle)
+	server, err := NewOpaContainer(ctx, bundle)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	report := RunTests(tests, workers, server.Address, nil)
+	if err = server.Container.Terminate(context.Background()); err != nil {
+		log.Error("failed to stop OPA container: %v", err)
+	}
+	result.KilledBy = KilledBy(baseline, report)
+	if len(result.KilledBy) > 0 {
+		result.Status = MutantKilled
+	} else {
+		result.Status = MutantSurvived
+	}
+	return result
+}
+
+// KilledBy returns the names of the tests which passed in the `baseline` report,
+// and failed in the `mutant` one.
+func KilledBy(baseline, mutant *testing.TestReport) []string {
+	var passed = make(map[string]bool)
+	for _, result := range baseline.Results {
+		if result.Passed {
+			passed[result.Name] = true
+		}
+	}
+	var names []string
+	for _, result := range mutant.Results {
+		if !result.Passed && passed[result.Name] {
+			names = append(names, result.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func exprText(expr *ast.Expr) string {
+	if expr.Location != nil && len(expr.Location.Text) > 0 {
+		return string(expr.Location.Text)
+	}
+	return expr.String()
+}
+
+// flippedOperator returns the operator of a comparison expression, and the one which
+// negates it (e.g., `!=` for `==`); the latter is nil for all other expressions.
+func flippedOperator(expr *ast.Expr) (*ast.Builtin, *ast.Builtin) {
+	if !expr.IsCall() {
+		return nil, nil
+	}
+	switch expr.Operator().String() {
+	case ast.Equality.Name:
+		return ast.Equality, ast.NotEqual
+	case ast.Equal.Name:
+		return ast.Equal, ast.NotEqual
+	case ast.NotEqual.Name:
+		return ast.NotEqual, ast.Equal
+	}
+	return nil, nil
+}
+
+func isLiteral(term *ast.Term) bool {
+	switch term.Value.(type) {
+	case ast.String, ast.Number, ast.Boolean:
+		return true
+	}
+	return false
+}
+
+// literals returns all the scalar (string, number and boolean) terms in the
+// expression, in a stable order; the path segments of references (e.g., the
+// "method" in `input.method`) are not considered literals.
+func literals(expr *ast.Expr) []*ast.Term {
+	var result []*ast.Term
+	var visit func(x interface{})
+	visit = func(x interface{}) {
+		ast.WalkTerms(x, func(term *ast.Term) bool {
+			if isLiteral(term) {
+				result = append(result, term)
+				return false
+			}
+			switch value := term.Value.(type) {
+			case ast.Ref:
+				for _, operand := range value[1:] {
+					if _, ok := operand.Value.(ast.String); !ok {
+						visit(operand)
+					}
+				}
+				return true
+			}
+			return false
+		})
+	}
+	visit(expr)
+	return result
+}
+
+func swapLiteral(value ast.Value) ast.Value {
+	switch v := value.(type) {
+	case ast.String:
+		if swapped, found := swappedLiterals[strings.ToUpper(string(v))]; found {
+			return ast.String(swapped)
+		}
+		return ast.String(string(v) + "_mutant")
+	case ast.Number:
+		if n, ok := v.Int(); ok {
+			return ast.IntNumberTerm(n + 1).Value
+		}
+		return ast.IntNumberTerm(0).Value
+	case ast.Boolean:
+		return !v
+	}
+	return value
+}
@@ -0,0 +1,115 @@
+package internals_test
+
+import (
+	"github.com/CopilotIQ/opa-tests/testing"
+	"github.com/CopilotIQ/opa-tests/testing/internals"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Mutations", func() {
+	var policies *internals.Policies
+	var mutants []*internals.Mutant
+	users := filepath.Join(examplePolicies, "users.rego")
+
+	BeforeEach(func() {
+		var err error
+		policies, err = internals.LoadPolicies(examplePolicies)
+		Expect(err).ShouldNot(HaveOccurred())
+		mutants = internals.GenerateMutants(policies)
+		Expect(mutants).ToNot(BeEmpty())
+	})
+	find := func(file string, line int, prefix string) *internals.Mutant {
+		for _, m := range mutants {
+			if m.File == file && m.Line == line && strings.HasPrefix(m.Description, prefix) {
+				return m
+			}
+		}
+		return nil
+	}
+
+	It("sorts the mutants by file and line", func() {
+		for i := 1; i < len(mutants); i++ {
+			prev, m := mutants[i-1], mutants[i]
+			Expect(prev.File <= m.File).To(BeTrue())
+			if prev.File == m.File {
+				Expect(prev.Line <= m.Line).To(BeTrue())
+			}
+		}
+	})
+	It("generates all the kinds of mutations", func() {
+		Expect(find(users, 20, "flipped `==` to `!=`")).ToNot(BeNil())
+		Expect(find(users, 20, "dropped `c.entity == \"users\"`")).ToNot(BeNil())
+		Expect(find(users, 20, "swapped \"users\" with \"users_mutant\"")).ToNot(BeNil())
+		Expect(find(users, 23, "removed `not`")).ToNot(BeNil())
+		Expect(find(users, 24, "swapped \"GET\" with \"POST\"")).ToNot(BeNil())
+		Expect(find(users, 19, "removed rule `allow`")).ToNot(BeNil())
+	})
+	It("only swaps the value of default rules", func() {
+		m := find(users, 10, "")
+		Expect(m).ToNot(BeNil())
+		Expect(m.Description).To(Equal("swapped false with true in `default allow`"))
+		Expect(find(users, 10, "removed rule")).To(BeNil())
+	})
+	It("does not consider the references' segments as literals", func() {
+		for _, m := range mutants {
+			Expect(m.Description).ToNot(ContainSubstring("\"method_mutant\""))
+			Expect(m.Description).ToNot(ContainSubstring("\"resource_mutant\""))
+		}
+	})
+	It("writes the mutated sources", func() {
+		m := find(users, 24, "swapped \"GET\" with \"POST\"")
+		dir, err := os.MkdirTemp("", "mutant-*")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		Expect(m.WriteSources(policies, dir)).To(Succeed())
+
+		files, _ := filepath.Glob(filepath.Join(dir, "*.rego"))
+		Expect(files).To(HaveLen(len(policies.Modules)))
+		source, err := os.ReadFile(filepath.Join(dir, "users.rego"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(source)).To(ContainSubstring(`input.resource.method in ["POST", "PUT"]`))
+		_, err = internals.LoadPolicies(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	It("leaves the original policies untouched", func() {
+		before := len(policies.Modules[users].Rules)
+		Expect(find(users, 19, "removed rule")).ToNot(BeNil())
+		Expect(policies.Modules[users].Rules).To(HaveLen(before))
+		Expect(policies.Modules[users].Rules[0].Default).To(BeTrue())
+	})
+})
+
+var _ = Describe("Mutation reports", func() {
+	It("kills mutants with the tests which passed against the original policies", func() {
+		baseline := &testing.TestReport{}
+		baseline.ReportSuccess(&testing.TestResult{Name: "one"})
+		baseline.ReportSuccess(&testing.TestResult{Name: "two"})
+		baseline.ReportFailure(&testing.TestResult{Name: "three"})
+		mutant := &testing.TestReport{}
+		mutant.ReportSuccess(&testing.TestResult{Name: "one"})
+		mutant.ReportFailure(&testing.TestResult{Name: "two"})
+		mutant.ReportFailure(&testing.TestResult{Name: "three"})
+		Expect(internals.KilledBy(baseline, mutant)).To(Equal([]string{"two"}))
+		Expect(internals.KilledBy(baseline, baseline)).To(BeEmpty())
+	})
+	It("computes the mutation score over the valid mutants", func() {
+		var report internals.MutationReport
+		report.Add(internals.MutantResult{File: "a.rego", Line: 1, Status: internals.MutantKilled})
+		report.Add(internals.MutantResult{File: "a.rego", Line: 2, Status: internals.MutantKilled})
+		report.Add(internals.MutantResult{File: "a.rego", Line: 3, Status: internals.MutantKilled})
+		report.Add(internals.MutantResult{File: "b.rego", Line: 4, Description: "dropped `x`",
+			Status: internals.MutantSurvived})
+		report.Add(internals.MutantResult{File: "b.rego", Line: 5, Status: internals.MutantInvalid})
+		Expect(report.Killed).To(Equal(uint(3)))
+		Expect(report.Survived).To(Equal(uint(1)))
+		Expect(report.Invalid).To(Equal(uint(1)))
+		Expect(report.Score).To(BeNumerically("~", 75.0))
+		Expect(report.Survivors()).To(HaveLen(1))
+		Expect(report.Survivors()[0].String()).To(Equal("b.rego:4: dropped `x`"))
+	})
+})
@@ -0,0 +1,175 @@
+package internals
+
+import (
+	"fmt"
+	"github.com/CopilotIQ/opa-tests/testing"
+	"github.com/open-policy-agent/opa/ast"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Policies are the Rego modules found in the sources directory, parsed and compiled;
+// they are used to validate the tests before starting the OPA server.
+type Policies struct {
+	Modules  map[string]*ast.Module
+	Compiler *ast.Compiler
+
+	// The prepared queries for the embedded engine, by endpoint
+	queries sync.Map
+}
+
+// An UnresolvedTarget is an Endpoint used by one or more tests which does
+// not match any of the rules defined in the Policies.
+type UnresolvedTarget struct {
+	Endpoint string
+	Tests    []string
+}
+
+func (u UnresolvedTarget) String() string {
+	return fmt.Sprintf("%s: no such rule (used by %s)", u.Endpoint, strings.Join(u.Tests, ", "))
+}
+
+// policyFiles returns all the Rego files in the `srcDir` directory, except the Rego
+// test modules (see regoTestFiles).
+func policyFiles(srcDir string) ([]string, error) {
+	// TODO: walk the subtree (instead of just the directory) and modify the test names to
+	// 		 reflect the position in the subtree using WalkDir(root string, fn fs.WalkDirFunc)
+	files, err := filepath.Glob(filepath.Join(srcDir, testing.PoliciesGlob))
+	if err != nil {
+		return nil, err
+	}
+	var policies []string
+	for _, file := range files {
+		if !isRegoTest(file) {
+			policies = append(policies, file)
+		}
+	}
+	if len(policies) == 0 {
+		return nil, fmt.Errorf("empty policies directory %s", srcDir)
+	}
+	return policies, nil
+}
+
+// regoTestFiles returns the Rego test modules (`*_test.rego`) in the `srcDir` directory.
+func regoTestFiles(srcDir string) ([]string, error) {
+	return filepath.Glob(filepath.Join(srcDir, testing.RegoTestsGlob))
+}
+
+func isRegoTest(file string) bool {
+	matched, _ := filepath.Match(testing.RegoTestsGlob, filepath.Base(file))
+	return matched
+}
+
+// LoadPolicies parses and compiles all the Rego files in `srcDir`; parsing and
+// compilation errors are returned (as ast.Errors) with their file and line.
+func LoadPolicies(srcDir string) (*Policies, error) {
+	files, err := policyFiles(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	var modules = make(map[string]*ast.Module, len(files))
+	var errs ast.Errors
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		module, err := ast.ParseModule(file, string(contents))
+		if err != nil {
+			if astErrs, ok := err.(ast.Errors); ok {
+				errs = append(errs, astErrs...)
+				continue
+			}
+			return nil, err
+		}
+		modules[file] = module
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	compiler := ast.NewCompiler()
+	compiler.Compile(modules)
+	if compiler.Failed() {
+		return nil, compiler.Errors
+	}
+	return &Policies{Modules: modules, Compiler: compiler}, nil
+}
+
+// EndpointRef converts a `/v1/data` endpoint (e.g., `copilotiq/allow`) to
+// the Rego reference for the document (`data.copilotiq.allow`).
+func EndpointRef(endpoint string) ast.Ref {
+	ref := ast.DefaultRootRef.Copy()
+	for _, segment := range strings.Split(strings.Trim(endpoint, UrlSep), UrlSep) {
+		ref = ref.Append(ast.StringTerm(segment))
+	}
+	return ref
+}
+
+// refEndpoint is the inverse of EndpointRef.
+func refEndpoint(ref ast.Ref) string {
+	var segments []string
+	for _, term := range ref[1:] {
+		if s, ok := term.Value.(ast.String); ok {
+			segments = append(segments, string(s))
+		} else {
+			segments = append(segments, term.String())
+		}
+	}
+	return strings.Join(segments, UrlSep)
+}
+
+// Rules returns the endpoints of all the rules defined in the Policies
+// (functions excluded, as they cannot be evaluated via the Data API), sorted.
+func (p *Policies) Rules() []string {
+	var found = make(map[string]bool)
+	for _, module := range p.Modules {
+		for _, rule := range module.Rules {
+			if len(rule.Head.Args) > 0 {
+				continue
+			}
+			found[refEndpoint(rule.Ref())] = true
+		}
+	}
+	var rules = make([]string, 0, len(found))
+	for rule := range found {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+// Resolves returns true if the `endpoint` matches a rule defined in the Policies.
+func (p *Policies) Resolves(endpoint string) bool {
+	for _, rule := range p.Compiler.GetRulesExact(EndpointRef(endpoint)) {
+		if len(rule.Head.Args) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTargets returns all the Endpoints used by the `tests` (with their positions,
+// if known) which do not resolve to a rule in the Policies, sorted by Endpoint.
+func ValidateTargets(policies *Policies, tests []testing.TestUnit) []UnresolvedTarget {
+	var unresolved = make(map[string][]string)
+	for _, test := range tests {
+		if !policies.Resolves(test.Endpoint) {
+			name := test.Name
+			if position := test.Position(); position != "" {
+				name = fmt.Sprintf("%s at %s", name, position)
+			}
+			unresolved[test.Endpoint] = append(unresolved[test.Endpoint], name)
+		}
+	}
+	var result = make([]UnresolvedTarget, 0, len(unresolved))
+	for endpoint, names := range unresolved {
+		result = append(result, UnresolvedTarget{Endpoint: endpoint, Tests: names})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Endpoint < result[j].Endpoint
+	})
+	return result
+}
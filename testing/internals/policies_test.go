@@ -0,0 +1,55 @@
+package internals_test
+
+import (
+	"github.com/CopilotIQ/opa-tests/testing"
+	"github.com/CopilotIQ/opa-tests/testing/internals"
+	"github.com/open-policy-agent/opa/ast"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	examplePolicies = "../../examples/policies"
+)
+
+var _ = Describe("Policies", func() {
+	var policies *internals.Policies
+	BeforeEach(func() {
+		var err error
+		policies, err = internals.LoadPolicies(examplePolicies)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("compiles all the modules", func() {
+		Expect(policies.Modules).To(HaveLen(3))
+		Expect(policies.Rules()).To(ContainElements("copilotiq/allow", "copilotiq/common/is_admin"))
+		// Functions cannot be targeted by the tests
+		Expect(policies.Rules()).ToNot(ContainElement("copilotiq/common/split_path"))
+	})
+	It("resolves the endpoints", func() {
+		Expect(policies.Resolves("copilotiq/allow")).To(BeTrue())
+		Expect(policies.Resolves("copilotiq/common/is_user")).To(BeTrue())
+		Expect(policies.Resolves("copilotiq/alow")).To(BeFalse())
+		Expect(policies.Resolves("copilotiq")).To(BeFalse())
+	})
+	It("reports the unresolved targets", func() {
+		tests := []testing.TestUnit{
+			{Name: "one", Endpoint: "copilotiq/allow"},
+			{Name: "two", Endpoint: "copilotiq/alow"},
+			{Name: "three", Endpoint: "copilotiq/alow"},
+		}
+		unresolved := internals.ValidateTargets(policies, tests)
+		Expect(unresolved).To(HaveLen(1))
+		Expect(unresolved[0].Endpoint).To(Equal("copilotiq/alow"))
+		Expect(unresolved[0].Tests).To(Equal([]string{"two", "three"}))
+	})
+	It("fails on empty directories", func() {
+		_, err := internals.LoadPolicies("../../testdata")
+		Expect(err).Should(HaveOccurred())
+	})
+	It("maps endpoints to references", func() {
+		ref := internals.EndpointRef("copilotiq/common/is_admin")
+		Expect(ref.Equal(ast.MustParseRef("data.copilotiq.common.is_admin"))).To(BeTrue())
+	})
+})
@@ -0,0 +1,94 @@
+package internals
+
+import (
+	"context"
+	"fmt"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/tester"
+	"os"
+	"strings"
+)
+
+// skippedRegoTest is the reason reported for the `todo_test_` rules.
+const skippedRegoTest = "todo Rego test"
+
+// RunRegoTests runs the `test_` rules of the Rego test modules (`*_test.rego`) in the
+// `srcDir` directory against the `policies`, with the embedded engine, and adds the
+// results of those matching the `filter` (if not nil) to the `report`.
+//
+// It returns the TestUnits of the reported Rego tests (named after their package,
+// e.g. `copilotiq.test_admin`), so that they can be shown with the other tests.
+func RunRegoTests(ctx context.Context, policies *Policies, srcDir string, filter *Filter,
+	report *TestReport) ([]TestUnit, error) {
+	files, err := regoTestFiles(srcDir)
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+	var modules = make(map[string]*ast.Module, len(policies.Modules)+len(files))
+	for file, module := range policies.Modules {
+		modules[file] = module
+	}
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if modules[file], err = ast.ParseModule(file, string(contents)); err != nil {
+			return nil, err
+		}
+	}
+	log.Debug("running the Rego tests in %s", files)
+	results, err := tester.NewRunner().SetStore(inmem.New()).Run(ctx, modules)
+	if err != nil {
+		return nil, err
+	}
+
+	var tests []TestUnit
+	for result := range results {
+		test := regoTestUnit(result)
+		if filter != nil && !filter.Matches(&test) {
+			continue
+		}
+		tests = append(tests, test)
+		testResult := &TestResult{Name: test.Name, Testcase: test.Testcase, File: test.File,
+			Line: test.Line, Endpoint: test.Endpoint, Expected: true, Duration: result.Duration}
+		switch {
+		case result.Skip:
+			report.ReportSkipped(test.Name, test.Skip)
+		case result.Error != nil:
+			testResult.Error = result.Error.Error()
+			report.ReportFailure(testResult)
+		case result.Fail:
+			testResult.Actual = false
+			if len(result.Output) > 0 {
+				testResult.Error = fmt.Sprintf("failed, with output: %s",
+					strings.TrimSpace(string(result.Output)))
+			}
+			report.ReportFailure(testResult)
+		default:
+			testResult.Actual = true
+			report.ReportSuccess(testResult)
+		}
+	}
+	return tests, nil
+}
+
+// regoTestUnit describes the Rego test of the `result` as a TestUnit.
+func regoTestUnit(result *tester.Result) TestUnit {
+	testcase := strings.TrimPrefix(result.Package, ast.DefaultRootDocument.String()+".")
+	test := TestUnit{
+		Name:        testcase + "." + result.Name,
+		Testcase:    testcase,
+		Endpoint:    strings.ReplaceAll(testcase, ".", UrlSep) + UrlSep + result.Name,
+		Expectation: true,
+	}
+	if result.Location != nil {
+		test.File, test.Line = result.Location.File, result.Location.Row
+	}
+	if result.Skip {
+		test.Skip = skippedRegoTest
+	}
+	return test
+}
@@ -0,0 +1,94 @@
+package internals_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"github.com/CopilotIQ/opa-tests/testing"
+	"github.com/CopilotIQ/opa-tests/testing/internals"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rego test modules", func() {
+	var policies *internals.Policies
+	BeforeEach(func() {
+		var err error
+		policies, err = internals.LoadPolicies(examplePolicies)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("are not loaded as policies", func() {
+		Expect(policies.Modules).ToNot(HaveKey(filepath.Join(examplePolicies, "common_test.rego")))
+		Expect(policies.Modules).To(HaveLen(3))
+	})
+	It("are not included in the bundle", func() {
+		bundle, err := internals.CreateBundle(filepath.Join(examplePolicies, "manifest.json"), examplePolicies)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.Remove(bundle)
+		Expect(bundleNames(bundle)).To(ConsistOf("common.rego", "tokens.rego", "users.rego", ".manifest"))
+	})
+	It("are run, and their results reported", func() {
+		report := &testing.TestReport{}
+		tests, err := internals.RunRegoTests(context.Background(), policies, examplePolicies, nil, report)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(tests).To(HaveLen(4))
+		Expect(tests[0].Name).To(Equal("copilotiq.common.test_split_path"))
+		Expect(tests[0].Testcase).To(Equal("copilotiq.common"))
+		Expect(tests[0].Endpoint).To(Equal("copilotiq/common/test_split_path"))
+		Expect(tests[0].Position()).To(Equal(filepath.Join(examplePolicies, "common_test.rego") + ":8"))
+		Expect(report.Succeeded).To(Equal(uint(3)))
+		Expect(report.Failed).To(BeZero())
+		Expect(report.SkippedNames).To(Equal([]string{"copilotiq.common.todo_test_is_system"}))
+	})
+	It("only reports the tests matching the filter", func() {
+		report := &testing.TestReport{}
+		filter := &testing.Filter{Run: regexp.MustCompile("entity")}
+		tests, err := internals.RunRegoTests(context.Background(), policies, examplePolicies, filter, report)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(tests).To(HaveLen(1))
+		Expect(report.Total).To(Equal(uint(1)))
+	})
+	It("reports the failed tests", func() {
+		dir, err := os.MkdirTemp("", "rego-tests")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		Expect(os.WriteFile(filepath.Join(dir, "users_test.rego"), []byte(`package copilotiq
+test_allow_anonymous {
+	print("no token")
+	allow with input as {"resource": {"path": "/users", "method": "GET"}}
+}
+`), 0600)).To(Succeed())
+		report := &testing.TestReport{}
+		tests, err := internals.RunRegoTests(context.Background(), policies, dir, nil, report)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(tests).To(HaveLen(1))
+		Expect(report.FailedNames).To(Equal([]string{"copilotiq.test_allow_anonymous"}))
+		Expect(report.Results[0].Error).To(ContainSubstring("no token"))
+	})
+	It("are not found in directories without any", func() {
+		tests, err := internals.RunRegoTests(context.Background(), policies, "../../testdata",
+			nil, &testing.TestReport{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(tests).To(BeEmpty())
+	})
+})
+
+// bundleNames returns the names of the files in the bundle at `path`.
+func bundleNames(path string) []string {
+	f, err := os.Open(path)
+	Expect(err).ShouldNot(HaveOccurred())
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	Expect(err).ShouldNot(HaveOccurred())
+	var names []string
+	archive := tar.NewReader(gz)
+	for header, err := archive.Next(); err == nil; header, err = archive.Next() {
+		names = append(names, header.Name)
+	}
+	return names
+}
@@ -0,0 +1,95 @@
+package internals
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"regexp"
+)
+
+// RedactedToken replaces the JWTs in the redacted Decisions.
+const RedactedToken = "<redacted>"
+
+// jwtPattern matches the (base64url-encoded) header, payload and signature of a JWT; the
+// header is a JSON object, hence always starts with `eyJ` (the encoding of `{"`).
+var jwtPattern = regexp.MustCompile(`^eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*$`)
+
+// Dedupe removes the Decisions with the same Endpoint and Input of a previous one.
+func Dedupe(decisions []Decision) []Decision {
+	var seen = make(map[string]bool, len(decisions))
+	var result []Decision
+	for _, decision := range decisions {
+		input, err := json.Marshal(decision.Input)
+		if err != nil {
+			result = append(result, decision)
+			continue
+		}
+		key := decision.Endpoint + "\x00" + string(input)
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, decision)
+		}
+	}
+	return result
+}
+
+// Sample returns a random selection of the `decisions` with the given `rate` (between 0 and 1),
+// preserving their order; the `seed` makes the selection reproducible.
+func Sample(decisions []Decision, rate float64, seed int64) []Decision {
+	if rate >= 1 {
+		return decisions
+	}
+	random := rand.New(rand.NewSource(seed))
+	var result []Decision
+	for _, decision := range decisions {
+		if random.Float64() < rate {
+			result = append(result, decision)
+		}
+	}
+	return result
+}
+
+// RedactTokens returns a copy of the JSON `value`, with all the strings which look
+// like a JWT replaced by RedactedToken.
+func RedactTokens(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if jwtPattern.MatchString(v) {
+			return RedactedToken
+		}
+		return v
+	case map[string]interface{}:
+		var result = make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = RedactTokens(item)
+		}
+		return result
+	case []interface{}:
+		var result = make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = RedactTokens(item)
+		}
+		return result
+	}
+	return value
+}
+
+// ReplayDecisions evaluates all the (logged) `decisions` against the OPA server at `addr`,
+// using `workers` parallel goroutines, and returns those whose result is not the logged
+// one, as changes From the logged result To the current one.
+func ReplayDecisions(addr string, decisions []Decision, workers uint) []DecisionChange {
+	url := fmt.Sprintf("http://%s", addr)
+	var changes = make([]*DecisionChange, len(decisions))
+	forEach(len(decisions), workers, func(i int) {
+		decision := decisions[i]
+		result, err := Query(url, decision.Endpoint, decision.Input)
+		if err != nil {
+			changes[i] = &DecisionChange{Decision: decision, From: decision.Result,
+				Error: fmt.Sprintf("cannot evaluate: %v", err)}
+		} else if !reflect.DeepEqual(result, decision.Result) {
+			changes[i] = &DecisionChange{Decision: decision, From: decision.Result, To: result}
+		}
+	})
+	return collectChanges(changes)
+}
@@ -0,0 +1,57 @@
+package internals_test
+
+import (
+	"github.com/CopilotIQ/opa-tests/testing/internals"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testDecisions = "../../testdata/decisions.jsonl"
+
+var _ = Describe("Replay", func() {
+	var decisions []internals.Decision
+	BeforeEach(func() {
+		var err error
+		decisions, err = internals.ReadCorpus(testDecisions)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(decisions).To(HaveLen(3))
+	})
+
+	It("reads the decision logs", func() {
+		Expect(decisions[0].ID).To(Equal("4ca636c1-55e4-417a-b1d8-4aceb67960d1"))
+		Expect(decisions[0].Endpoint).To(Equal("copilotiq/allow"))
+		Expect(decisions[0].Result).To(Equal(true))
+		Expect(decisions[2].Result).To(Equal(false))
+	})
+	It("dedupes the decisions with the same input", func() {
+		deduped := internals.Dedupe(decisions)
+		Expect(deduped).To(HaveLen(2))
+		Expect(deduped[0].ID).To(HaveSuffix("d1"))
+		Expect(deduped[1].ID).To(HaveSuffix("d3"))
+	})
+	It("samples the decisions", func() {
+		Expect(internals.Sample(decisions, 1, 0)).To(Equal(decisions))
+		Expect(internals.Sample(decisions, 0.5, 42)).To(Equal(internals.Sample(decisions, 0.5, 42)))
+		var many = make([]internals.Decision, 1000)
+		Expect(len(internals.Sample(many, 0.1, 7))).To(BeNumerically("~", 100, 30))
+	})
+	It("redacts the tokens", func() {
+		redacted := internals.RedactTokens(decisions[0].Input).(map[string]interface{})
+		Expect(redacted["api_token"]).To(Equal(internals.RedactedToken))
+		Expect(redacted["resource"]).To(Equal(decisions[0].Input.(map[string]interface{})["resource"]))
+		// The original is unchanged, and only JWTs are redacted
+		Expect(decisions[0].Input.(map[string]interface{})["api_token"]).To(HavePrefix("eyJ"))
+		host := internals.RedactTokens(decisions[2].Input).(map[string]interface{})["resource"]
+		Expect(host).To(HaveKeyWithValue("host", "www.example.com"))
+	})
+	It("reports the decisions whose result changed", func() {
+		server := fakeOpa(map[string]interface{}{"copilotiq/allow": true})
+		defer server.Close()
+		mismatches := internals.ReplayDecisions(strings.TrimPrefix(server.URL, "http://"), decisions, 2)
+		Expect(mismatches).To(HaveLen(1))
+		Expect(mismatches[0].ID).To(HaveSuffix("d3"))
+		Expect(mismatches[0].String()).To(Equal(testDecisions + ":3 (copilotiq/allow): false -> true"))
+	})
+})
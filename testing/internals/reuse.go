@@ -0,0 +1,316 @@
+package internals
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+	"github.com/testcontainers/testcontainers-go"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// ReusedContainerName is the default name of the OPA container reused across runs.
+	ReusedContainerName = "opatest-opa"
+	// ReusedLabel marks the OPA containers created to be reused.
+	ReusedLabel = "com.copilotiq.opatest.reuse"
+
+	// ReuseHealthTimeout is how long each attempt to start the reused container has to
+	// find the OPA server healthy.
+	ReuseHealthTimeout = 20 * time.Second
+
+	healthPollInterval = 250 * time.Millisecond
+)
+
+// ReuseOpaContainer returns the OPA server running in the `name` Docker container, which is
+// started (or created, if it is gone) as needed, and recreated if it is not healthy, or
+// runs another OPA image than OpaImage.
+//
+// Unlike the servers started by NewOpaContainer, it has no bundle: the policies in the
+// bundle at `bundlePath` (if any) are uploaded with PushBundle, and the error includes
+// those logged by OPA. It is left running by Stop, to be reused by the next run;
+// use `docker rm -f NAME` to remove it. A container with the same name, which was not
+// created by ReuseOpaContainer, is never reused (nor removed).
+//
+// It uses the same Docker host as TestContainers, but none of its reaper labels, so that
+// the container outlives the run.
+func ReuseOpaContainer(ctx context.Context, name string, bundlePath string) (*OpaServer, error) {
+	provider, err := testcontainers.NewDockerProvider()
+	if err != nil {
+		return nil, err
+	}
+	defer provider.Close()
+	start := time.Now()
+	address, err := startContainer(ctx, provider, name)
+	if err != nil {
+		return nil, err
+	}
+	// Only a container of ours which is not healthy is recreated (see startContainer)
+	if err := waitReusedHealthy(ctx, address); err != nil {
+		log.Warn("OPA container %s is not healthy (%v), recreating it", name, err)
+		if err := removeContainer(ctx, provider, name); err != nil {
+			return nil, err
+		}
+		if address, err = startContainer(ctx, provider, name); err != nil {
+			return nil, err
+		}
+		if err := waitReusedHealthy(ctx, address); err != nil {
+			return nil, err
+		}
+	}
+	server := &OpaServer{Address: address, BundleFilepath: bundlePath, reused: name, started: start}
+	if bundlePath != "" {
+		if err := PushBundle(address, bundlePath); err != nil {
+			return nil, fmt.Errorf("%v%s", err, opaLogErrors(server))
+		}
+	}
+	return server, nil
+}
+
+// startContainer starts the `name` container (creating it, if it does not exist, or
+// recreating it, if its image is not OpaImage), and returns the address of its OPA server.
+// It fails on a container which was not created by createContainer.
+func startContainer(ctx context.Context, provider *testcontainers.DockerProvider, name string) (string, error) {
+	cli := provider.Client()
+	info, err := cli.ContainerInspect(ctx, name)
+	switch {
+	case client.IsErrNotFound(err):
+		log.Info("creating OPA container %s", name)
+		err = createContainer(ctx, provider, name)
+	case err != nil:
+		return "", fmt.Errorf("cannot inspect container %s: %v", name, err)
+	case info.Config.Labels[ReusedLabel] != "true":
+		return "", fmt.Errorf("container %s was not created to be reused by opatest (it has no %s label): "+
+			"remove it, or use another name", name, ReusedLabel)
+	case info.Config.Image != OpaImage:
+		log.Info("OPA container %s runs %s, recreating it with %s", name, info.Config.Image, OpaImage)
+		if err = removeContainer(ctx, provider, name); err == nil {
+			err = createContainer(ctx, provider, name)
+		}
+	case !info.State.Running:
+		log.Info("starting OPA container %s", name)
+		err = cli.ContainerStart(ctx, name, container.StartOptions{})
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot start container %s: %v", name, err)
+	}
+	if info, err = cli.ContainerInspect(ctx, name); err != nil {
+		return "", fmt.Errorf("cannot inspect container %s: %v", name, err)
+	}
+	bindings := info.NetworkSettings.Ports[opaContainerPort]
+	if len(bindings) == 0 {
+		return "", fmt.Errorf("port %s of container %s is not published", OpaPort, name)
+	}
+	return fmt.Sprintf("%s:%s", bindings[0].HostIP, bindings[0].HostPort), nil
+}
+
+// waitReusedHealthy waits for the OPA server at `addr` to be healthy, for up to
+// ReuseHealthTimeout: each attempt has its own timeout, so that a container recreated
+// after the first one failed still has the time to start.
+func waitReusedHealthy(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithTimeout(ctx, ReuseHealthTimeout)
+	defer cancel()
+	return waitHealthy(ctx, addr)
+}
+
+// opaContainerPort is the OpaPort of the container.
+var opaContainerPort = nat.Port(OpaPort + "/tcp")
+
+// createContainer creates, and starts, the `name` container, pulling the OpaImage if needed.
+func createContainer(ctx context.Context, provider *testcontainers.DockerProvider, name string) error {
+	cli := provider.Client()
+	if _, err := cli.ImageInspect(ctx, OpaImage); err != nil {
+		if err := provider.PullImage(ctx, OpaImage); err != nil {
+			return err
+		}
+	}
+	// The decision logs are always enabled, as the container may be reused with -opa-logs
+	config := &container.Config{
+		Image:        OpaImage,
+		Cmd:          append([]string{"run", "--server", "--addr", fmt.Sprintf(":%s", OpaPort)}, DecisionLogsFlags...),
+		ExposedPorts: nat.PortSet{opaContainerPort: struct{}{}},
+		Labels:       map[string]string{ReusedLabel: "true"},
+	}
+	hostConfig := &container.HostConfig{
+		PortBindings: nat.PortMap{opaContainerPort: []nat.PortBinding{{HostIP: "127.0.0.1"}}},
+	}
+	if _, err := cli.ContainerCreate(ctx, config, hostConfig, nil, nil, name); err != nil {
+		return err
+	}
+	return cli.ContainerStart(ctx, name, container.StartOptions{})
+}
+
+func removeContainer(ctx context.Context, provider *testcontainers.DockerProvider, name string) error {
+	err := provider.Client().ContainerRemove(ctx, name, container.RemoveOptions{Force: true})
+	if err != nil && !client.IsErrNotFound(err) {
+		return fmt.Errorf("cannot remove container %s: %v", name, err)
+	}
+	return nil
+}
+
+// reusedLogs returns the output of the `name` container since the `since` time.
+func reusedLogs(ctx context.Context, name string, since time.Time) ([]byte, error) {
+	provider, err := testcontainers.NewDockerProvider()
+	if err != nil {
+		return nil, err
+	}
+	defer provider.Close()
+	logs, err := provider.Client().ContainerLogs(ctx, name, container.LogsOptions{ShowStdout: true,
+		ShowStderr: true, Since: fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the logs of container %s: %v", name, err)
+	}
+	defer logs.Close()
+	// The output of containers without a TTY is multiplexed
+	var out bytes.Buffer
+	if _, err := stdcopy.StdCopy(&out, &out, logs); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// waitHealthy polls the health endpoint of the OPA server at `addr`, until it responds, or
+// the `ctx` is done.
+func waitHealthy(ctx context.Context, addr string) error {
+	url := fmt.Sprintf("http://%s/health", addr)
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("health check returned %s", resp.Status)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("OPA server at %s is not healthy: %v", addr, err)
+		case <-time.After(healthPollInterval):
+		}
+	}
+}
+
+// PushBundle replaces all the policies on the OPA server at `addr` with those in the bundle
+// at `bundlePath` (see replacePolicies).
+func PushBundle(addr string, bundlePath string) error {
+	sources, err := bundleSources(bundlePath)
+	if err != nil {
+		return err
+	}
+	return replacePolicies(addr, sources)
+}
+
+// replacePolicies replaces all the policies on the OPA server at `addr` with the `sources`,
+// by ID, via the Policy API: they are uploaded first, and then those which are not among
+// them deleted (see applyPolicyChanges); the policies are then read back, to make sure that
+// the server has exactly the `sources`.
+func replacePolicies(addr string, sources map[string][]byte) error {
+	ids, err := policyIds(addr)
+	if err != nil {
+		return err
+	}
+	var changes []policyChange
+	for id, source := range sources {
+		changes = append(changes, policyChange{method: http.MethodPut, id: id, source: source})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].id < changes[j].id })
+	sort.Strings(ids)
+	for _, id := range ids {
+		if _, found := sources[id]; !found {
+			changes = append(changes, policyChange{method: http.MethodDelete, id: id})
+		}
+	}
+	if err := applyPolicyChanges(addr, changes); err != nil {
+		return err
+	}
+	return checkPolicies(addr, sources)
+}
+
+// checkPolicies fails unless the policies on the OPA server at `addr` are the `sources`.
+func checkPolicies(addr string, sources map[string][]byte) error {
+	policies, err := serverPolicies(addr)
+	if err != nil {
+		return err
+	}
+	for id, source := range sources {
+		raw, found := policies[id]
+		if !found {
+			return fmt.Errorf("policy %s is missing on the OPA server", id)
+		}
+		if raw != string(source) {
+			return fmt.Errorf("policy %s on the OPA server differs from its source", id)
+		}
+	}
+	for id := range policies {
+		if _, found := sources[id]; !found {
+			return fmt.Errorf("policy %s on the OPA server should have been deleted", id)
+		}
+	}
+	return nil
+}
+
+// bundleSources returns the Rego files in the bundle at `bundlePath`, by name.
+func bundleSources(bundlePath string) (map[string][]byte, error) {
+	var sources = make(map[string][]byte)
+	err := readBundle(bundlePath, func(name string, contents io.Reader) error {
+		if matched, _ := filepath.Match(PoliciesGlob, filepath.Base(name)); !matched {
+			return nil
+		}
+		source, err := io.ReadAll(contents)
+		sources[policyId(name)] = source
+		return err
+	})
+	return sources, err
+}
+
+// policyIds returns the IDs of all the policies on the OPA server at `addr`.
+func policyIds(addr string) ([]string, error) {
+	policies, err := serverPolicies(addr)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for id := range policies {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// serverPolicies returns the sources of all the policies on the OPA server at `addr`, by ID.
+func serverPolicies(addr string) (map[string]string, error) {
+	resp, err := http.Get(fmt.Sprintf("http://%s/%s", addr, v1Policies))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot list the policies: %s", opaError(resp))
+	}
+	var policies struct {
+		Result []struct {
+			ID  string `json:"id"`
+			Raw string `json:"raw"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&policies); err != nil {
+		return nil, err
+	}
+	var sources = make(map[string]string)
+	for _, policy := range policies.Result {
+		sources[policy.ID] = policy.Raw
+	}
+	return sources, nil
+}
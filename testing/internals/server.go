@@ -1,13 +1,23 @@
 package internals
 
 import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/CopilotIQ/opa-tests/testing"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"io"
 	"net/http"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
 // This package contains all the code necessary to run a self-contained OPA
@@ -17,6 +27,12 @@ import (
 // It uses TestContainers to run OPA in a docker container.
 
 const (
+	// BundleActivationTimeout is how long a started OPA server has to activate the bundle.
+	BundleActivationTimeout = 30 * time.Second
+
+	// opaLogLines are the lines of the OPA server logs shown, if it logged no errors.
+	opaLogLines = 10
+
 	// OpaImage is the Docker image to be used for tests.
 	// TODO: make the OPA version a configuration value
 	OpaImage     = "openpolicyagent/opa:0.47.4"
@@ -29,34 +45,85 @@ type OpaServer struct {
 	Address        string
 	BundleFilepath string
 	Container      testcontainers.Container
+
+	// The name of the container, if reused (see ReuseOpaContainer), and when this run
+	// started using it
+	reused  string
+	started time.Time
 }
 
-func (s *OpaServer) GetEndpoint(endpoint string) (*http.Response, error) {
-	return http.Get(fmt.Sprintf("http://%s%s", s.Address, endpoint))
+// Name returns the name of the container running the server.
+func (s *OpaServer) Name(ctx context.Context) string {
+	if s.reused != "" {
+		return s.reused
+	}
+	name, _ := s.Container.Name(ctx)
+	return name
 }
 
-func NewOpaContainer(ctx context.Context, bundlePath string) (*OpaServer, error) {
-	// Note that Docker will only mount the full path of the directory that contains the bundle
-	bundleDir, err := filepath.Abs(filepath.Dir(bundlePath))
+// Stop terminates the container running the server, unless it is reused.
+func (s *OpaServer) Stop(ctx context.Context) error {
+	if s.reused != "" {
+		return nil
+	}
+	return s.Container.Terminate(ctx)
+}
+
+// Logs returns the output (both stdout and stderr) of the container running the server;
+// for a reused container, only the output since it was reused by this run.
+func (s *OpaServer) Logs(ctx context.Context) ([]byte, error) {
+	if s.reused != "" {
+		return reusedLogs(ctx, s.reused, s.started)
+	}
+	logs, err := s.Container.Logs(ctx)
 	if err != nil {
 		return nil, err
 	}
-	bundle := filepath.Base(bundlePath)
+	defer logs.Close()
+	return io.ReadAll(logs)
+}
+
+func (s *OpaServer) GetEndpoint(endpoint string) (*http.Response, error) {
+	return http.Get(fmt.Sprintf("http://%s%s", s.Address, endpoint))
+}
+
+// NewOpaContainer starts an OPA server, loading the bundle at `bundlePath`; if empty, the
+// server starts without any policies, which can then be uploaded with PutPolicies.
+//
+// The server is only returned once the bundle is activated, with the revision of its
+// manifest; otherwise, the error includes those logged by OPA (e.g., compilation errors).
+//
+// The `flags` are added to the `opa run` command (e.g., DecisionLogsFlags).
+func NewOpaContainer(ctx context.Context, bundlePath string, flags ...string) (*OpaServer, error) {
 	req := testcontainers.ContainerRequest{
 		Image:        OpaImage,
 		ExposedPorts: []string{OpaPort},
-		Binds: []string{
-			strings.Join([]string{bundleDir, OpaBundleDir}, ":"),
-		},
-		Cmd: []string{"run", "--server", "--addr", fmt.Sprintf(":%s", OpaPort),
-			filepath.Join(OpaBundleDir, bundle)},
-		WaitingFor: wait.ForHTTP("/health").WithPort(OpaPort),
+		Cmd:          append([]string{"run", "--server", "--addr", fmt.Sprintf(":%s", OpaPort)}, flags...),
+		WaitingFor:   wait.ForHTTP("/health?bundles").WithPort(OpaPort),
+	}
+	var manifest *testing.BundleManifest
+	if bundlePath != "" {
+		var err error
+		if manifest, err = ReadBundleManifest(bundlePath); err != nil {
+			return nil, err
+		}
+		// Note that Docker will only mount the full path of the directory that contains the bundle
+		bundleDir, err := filepath.Abs(filepath.Dir(bundlePath))
+		if err != nil {
+			return nil, err
+		}
+		req.Binds = []string{strings.Join([]string{bundleDir, OpaBundleDir}, ":")}
+		req.Cmd = append(req.Cmd, "--bundle", filepath.Join(OpaBundleDir, filepath.Base(bundlePath)))
 	}
 	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
 		ContainerRequest: req,
 		Started:          true,
 	})
 	if err != nil {
+		if container != nil {
+			err = fmt.Errorf("%v%s", err, opaLogErrors(&OpaServer{Container: container}))
+			_ = container.Terminate(context.Background())
+		}
 		return nil, err
 	}
 
@@ -71,5 +138,152 @@ func NewOpaContainer(ctx context.Context, bundlePath string) (*OpaServer, error)
 	}
 
 	address := fmt.Sprintf("%s:%s", hostIP, mappedPort.Port())
+	if manifest != nil {
+		if err := waitForBundle(ctx, address, manifest.Revision); err != nil {
+			err = fmt.Errorf("%v%s", err, opaLogErrors(&OpaServer{Container: container}))
+			_ = container.Terminate(context.Background())
+			return nil, err
+		}
+	}
 	return &OpaServer{Container: container, Address: address, BundleFilepath: bundlePath}, nil
 }
+
+// waitForBundle polls the OPA server at `addr` until a bundle with the given `revision` is
+// activated, for up to BundleActivationTimeout.
+func waitForBundle(ctx context.Context, addr string, revision string) error {
+	ctx, cancel := context.WithTimeout(ctx, BundleActivationTimeout)
+	defer cancel()
+	for {
+		revisions, err := ActiveRevisions(addr)
+		if err == nil {
+			for _, active := range revisions {
+				if active == revision {
+					return nil
+				}
+			}
+			if len(revisions) == 0 {
+				err = fmt.Errorf("no bundle activated")
+			} else {
+				err = fmt.Errorf("bundle revision %s activated, expected %s",
+					strings.Join(revisions, ", "), revision)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(healthPollInterval):
+		}
+	}
+}
+
+// ActiveRevisions returns the revisions of the bundles activated on the OPA server at `addr`.
+func ActiveRevisions(addr string) ([]string, error) {
+	resp, err := http.Get(fmt.Sprintf("http://%s/%s/system/bundles", addr, v1Data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot read the bundles: %s", opaError(resp))
+	}
+	var bundles struct {
+		Result map[string]struct {
+			Manifest testing.BundleManifest `json:"manifest"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&bundles); err != nil {
+		return nil, err
+	}
+	var revisions []string
+	for _, bundle := range bundles.Result {
+		revisions = append(revisions, bundle.Manifest.Revision)
+	}
+	sort.Strings(revisions)
+	return revisions, nil
+}
+
+// opaLogErrors returns the errors logged by the OPA `server` (or, if none is found, the
+// last lines of its logs), each on a new line.
+func opaLogErrors(server *OpaServer) string {
+	logs, err := server.Logs(context.Background())
+	if err != nil {
+		return ""
+	}
+	var errors, lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(logs))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+		var entry struct {
+			Level   string `json:"level"`
+			Message string `json:"msg"`
+			Error   string `json:"err"`
+		}
+		if json.Unmarshal([]byte(line), &entry) == nil {
+			if entry.Level == "error" {
+				if entry.Error != "" {
+					entry.Message += ": " + entry.Error
+				}
+				errors = append(errors, entry.Message)
+			}
+		} else if strings.Contains(strings.ToLower(line), "error") {
+			errors = append(errors, line)
+		}
+	}
+	if len(errors) == 0 {
+		errors = lines[max(0, len(lines)-opaLogLines):]
+	}
+	if len(errors) == 0 {
+		return ""
+	}
+	return "\nOPA server logs:\n  " + strings.Join(errors, "\n  ")
+}
+
+// ReadBundleManifest returns the manifest in the bundle at `bundlePath`.
+func ReadBundleManifest(bundlePath string) (*testing.BundleManifest, error) {
+	var manifest *testing.BundleManifest
+	err := readBundle(bundlePath, func(name string, contents io.Reader) error {
+		if filepath.Base(name) != ".manifest" {
+			return nil
+		}
+		manifest = &testing.BundleManifest{}
+		return json.NewDecoder(contents).Decode(manifest)
+	})
+	if err == nil && manifest == nil {
+		err = fmt.Errorf("no manifest in bundle %s", bundlePath)
+	}
+	return manifest, err
+}
+
+// readBundle calls `fn` with the name and contents of each of the files in the bundle
+// at `bundlePath`.
+func readBundle(bundlePath string, fn func(name string, contents io.Reader) error) error {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header.Name, archive); err != nil {
+			return err
+		}
+	}
+}
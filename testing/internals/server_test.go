@@ -1,11 +1,18 @@
 package internals_test
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/CopilotIQ/opa-tests/testing/internals"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -14,6 +21,49 @@ type PoliciesList struct {
 	Result []Policy
 }
 
+var _ = Describe("Bundles", func() {
+	It("have a manifest", func() {
+		manifest, err := internals.ReadBundleManifest(testBundle)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(manifest.Revision).To(Equal("0.6.26"))
+		Expect(manifest.Roots).To(Equal([]string{"copilotiq"}))
+	})
+	It("are reported as activated, with their revisions", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/v1/data/system/bundles"))
+			_, _ = w.Write([]byte(`{"result": {"/etc/opa/bundles/authz.tar.gz": {"manifest": {"revision": "1.2"}},
+"other": {"manifest": {"revision": "0.1"}}}}`))
+		}))
+		defer server.Close()
+		Expect(internals.ActiveRevisions(strings.TrimPrefix(server.URL, "http://"))).To(Equal([]string{"0.1", "1.2"}))
+	})
+	It("are reproducible", func() {
+		var digests []string
+		for _, mode := range []os.FileMode{0600, 0755} {
+			// The same sources, written at different times, with different modes
+			dir, err := os.MkdirTemp("", "bundle")
+			Expect(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			for _, name := range []string{"users.rego", "common.rego", "tokens.rego"} {
+				source, err := os.ReadFile(filepath.Join(examplePolicies, name))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(os.WriteFile(filepath.Join(dir, name), source, mode)).To(Succeed())
+				modTime := time.Now().Add(-time.Duration(mode) * time.Hour)
+				Expect(os.Chtimes(filepath.Join(dir, name), modTime, modTime)).To(Succeed())
+			}
+			bundle, err := internals.CreateBundle(filepath.Join(examplePolicies, "manifest.json"), dir)
+			Expect(err).ShouldNot(HaveOccurred())
+			defer os.Remove(bundle)
+			Expect(bundleNames(bundle)).To(Equal([]string{".manifest", "common.rego", "tokens.rego", "users.rego"}))
+			digest, err := internals.BundleSHA256(bundle)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(digest).To(HaveLen(64))
+			digests = append(digests, digest)
+		}
+		Expect(digests[0]).To(Equal(digests[1]))
+	})
+})
+
 var _ = Describe("OPA Server", func() {
 
 	When("the tests start", func() {
@@ -30,22 +80,93 @@ var _ = Describe("OPA Server", func() {
 			}, 5*time.Second).Should(Equal(http.StatusOK))
 		})
 		It("has the correct bundle", func() {
-			Eventually(func() bool {
-				res, err := OpaContainer.GetEndpoint("/v1/policies")
-				if err != nil {
-					return false
-				}
-				var bundledPolicies PoliciesList
-				decoder := json.NewDecoder(res.Body)
-				defer res.Body.Close()
-				if err = decoder.Decode(&bundledPolicies); err == nil {
-					for _, policy := range bundledPolicies.Result {
-						Expect(testPolicies).To(ContainElement(policy["id"]))
-					}
-					return len(bundledPolicies.Result) == len(testPolicies)
-				}
-				return false
-			}, 10*time.Second).Should(BeTrue())
+			// The server is only returned once the bundle is activated
+			res, err := OpaContainer.GetEndpoint("/v1/policies")
+			Expect(err).ShouldNot(HaveOccurred())
+			defer res.Body.Close()
+			var bundledPolicies PoliciesList
+			Expect(json.NewDecoder(res.Body).Decode(&bundledPolicies)).To(Succeed())
+			Expect(bundledPolicies.Result).To(HaveLen(len(testPolicies)))
+			for _, policy := range bundledPolicies.Result {
+				Expect(testPolicies).To(ContainElement(policy["id"]))
+			}
+			Expect(internals.ActiveRevisions(OpaContainer.Address)).To(Equal([]string{"0.6.26"}))
+		})
+	})
+	When("the bundle cannot be activated", func() {
+		It("fails with the OPA errors", func() {
+			dir, err := os.MkdirTemp("", "bundle")
+			Expect(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			Expect(os.WriteFile(filepath.Join(dir, "broken.rego"),
+				[]byte("package copilotiq\nallow { undefined_function(input) }\n"), 0600)).To(Succeed())
+			bundle, err := internals.CreateBundle(filepath.Join(examplePolicies, "manifest.json"), dir)
+			Expect(err).ShouldNot(HaveOccurred())
+			defer os.Remove(bundle)
+			_, err = internals.NewOpaContainer(context.Background(), bundle)
+			Expect(err).Should(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("OPA server logs:"))
+			Expect(err.Error()).To(ContainSubstring("undefined function undefined_function"))
+		})
+	})
+	When("the bundle is signed", func() {
+		It("verifies its signature", func() {
+			dir, err := os.MkdirTemp("", "signing")
+			Expect(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			secret := filepath.Join(dir, "secret")
+			Expect(os.WriteFile(secret, []byte("s3cr3t"), 0600)).To(Succeed())
+			signing, err := internals.NewBundleSigning(secret, "", "HS256", "")
+			Expect(err).ShouldNot(HaveOccurred())
+			bundle, err := internals.CreateSignedBundle(filepath.Join(examplePolicies, "manifest.json"),
+				examplePolicies, signing)
+			Expect(err).ShouldNot(HaveOccurred())
+			defer os.Remove(bundle)
+			flags, keyCopy, err := signing.VerificationFlags(bundle)
+			Expect(err).ShouldNot(HaveOccurred())
+			defer os.Remove(keyCopy)
+			Expect(flags).ToNot(ContainElement("s3cr3t"))
+			server, err := internals.NewOpaContainer(context.Background(), bundle, flags...)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(server.Stop(context.Background())).To(Succeed())
+
+			unsigned, err := internals.CreateBundle(filepath.Join(examplePolicies, "manifest.json"), examplePolicies)
+			Expect(err).ShouldNot(HaveOccurred())
+			defer os.Remove(unsigned)
+			_, err = internals.NewOpaContainer(context.Background(), unsigned, flags...)
+			Expect(err).To(MatchError(ContainSubstring("missing .signatures.json")))
+		})
+	})
+	When("reused", func() {
+		const name = "opatest-reuse-test"
+		AfterEach(func() {
+			_ = exec.Command("docker", "rm", "--force", name).Run()
+		})
+		It("keeps running, with the pushed bundle", func() {
+			ctx := context.Background()
+			server, err := internals.ReuseOpaContainer(ctx, name, testBundle)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(server.Stop(ctx)).To(Succeed())
+
+			reused, err := internals.ReuseOpaContainer(ctx, name, "")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(reused.Address).To(Equal(server.Address))
+			res, err := reused.GetEndpoint("/v1/policies")
+			Expect(err).ShouldNot(HaveOccurred())
+			defer res.Body.Close()
+			var policies PoliciesList
+			Expect(json.NewDecoder(res.Body).Decode(&policies)).To(Succeed())
+			Expect(policies.Result).To(HaveLen(len(testPolicies)))
+
+			// A container which is gone is recreated
+			Expect(exec.Command("docker", "rm", "--force", name).Run()).To(Succeed())
+			_, err = internals.ReuseOpaContainer(ctx, name, "")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("does not adopt a container it did not create", func() {
+			Expect(exec.Command("docker", "create", "--name", name, internals.OpaImage, "run").Run()).To(Succeed())
+			_, err := internals.ReuseOpaContainer(context.Background(), name, "")
+			Expect(err).To(MatchError(ContainSubstring("was not created to be reused")))
 		})
 	})
 })
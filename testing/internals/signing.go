@@ -0,0 +1,152 @@
+package internals
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/keys"
+	"github.com/open-policy-agent/opa/util"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// SignaturesFile is the name of the bundle file with the signatures of all the others.
+	SignaturesFile = ".signatures.json"
+
+	// DefaultSigningAlg and DefaultSigningKeyID are the same as OPA's defaults.
+	DefaultSigningAlg   = "RS256"
+	DefaultSigningKeyID = "default"
+)
+
+// SigningAlgs are the supported algorithms to sign the bundles with.
+var SigningAlgs = []string{"HS256", "HS384", "HS512", "RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// A BundleSigning is the configuration to sign the bundles with (see CreateSignedBundle),
+// and verify them.
+type BundleSigning struct {
+	// Key is the path of the file with the secret (for the HS algorithms), or the PEM
+	// private key (for all the others).
+	Key string
+	// VerificationKey is the path of the file with the PEM public key the signatures are
+	// verified with; for the HS algorithms, it defaults to the secret.
+	VerificationKey string
+	Algorithm       string
+	KeyID           string
+}
+
+// NewBundleSigning returns the BundleSigning with the keys at `keyPath` and
+// `verificationKeyPath`, validating the algorithm, and using OPA's defaults for
+// those not given.
+func NewBundleSigning(keyPath string, verificationKeyPath string, alg string, keyID string) (*BundleSigning, error) {
+	if alg == "" {
+		alg = DefaultSigningAlg
+	}
+	if keyID == "" {
+		keyID = DefaultSigningKeyID
+	}
+	if !isSigningAlg(alg) {
+		return nil, fmt.Errorf("invalid signing algorithm %s, must be one of: %s",
+			alg, strings.Join(SigningAlgs, ", "))
+	}
+	if verificationKeyPath == "" {
+		if !strings.HasPrefix(alg, "HS") {
+			return nil, fmt.Errorf("the %s signatures need the public key to be verified", alg)
+		}
+		verificationKeyPath = keyPath
+	}
+	for _, path := range []string{keyPath, verificationKeyPath} {
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("cannot read the signing key: %v", err)
+		}
+	}
+	return &BundleSigning{Key: keyPath, VerificationKey: verificationKeyPath, Algorithm: alg, KeyID: keyID}, nil
+}
+
+func isSigningAlg(alg string) bool {
+	for _, a := range SigningAlgs {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// Signatures returns the contents of the SignaturesFile for the bundle made of the `files`:
+// a JWT, signed with the Key, with the SHA-256 hashes of all of them.
+//
+// As for OPA, the hashes of the structured files (e.g., the `.manifest`) are computed on
+// their JSON contents, so that they do not depend on their formatting.
+func (s *BundleSigning) Signatures(files []string) ([]byte, error) {
+	hasher, err := bundle.NewSignatureHasher(bundle.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	var hashes []bundle.FileInfo
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{} = contents
+		if bundle.IsStructuredDoc(file) {
+			if err := util.Unmarshal(contents, &value); err != nil {
+				return nil, fmt.Errorf("cannot parse %s: %v", file, err)
+			}
+		}
+		hash, err := hasher.HashFile(value)
+		if err != nil {
+			return nil, err
+		}
+		// Names are those of the files in the tarball (see tarFiles)
+		hashes = append(hashes, bundle.NewFile(filepath.Base(file), hex.EncodeToString(hash),
+			bundle.SHA256.String()))
+	}
+	token, err := bundle.GenerateSignedToken(hashes, bundle.NewSigningConfig(s.Key, s.Algorithm, ""), s.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign the bundle with %s: %v", s.Key, err)
+	}
+	return json.Marshal(bundle.SignaturesConfig{Signatures: []string{token}})
+}
+
+// VerifyBundle verifies the signatures of the bundle at `bundlePath` (and that all of its
+// files are signed), the same way the OPA server does when started with the
+// VerificationFlags.
+func (s *BundleSigning) VerifyBundle(bundlePath string) error {
+	key, err := keys.NewKeyConfig(s.VerificationKey, s.Algorithm, "")
+	if err != nil {
+		return err
+	}
+	config := bundle.NewVerificationConfig(map[string]*bundle.KeyConfig{s.KeyID: key}, s.KeyID, "", nil)
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = bundle.NewCustomReader(bundle.NewTarballLoaderWithBaseURL(f, "")).
+		WithBundleVerificationConfig(config).Read()
+	if err != nil {
+		return fmt.Errorf("bundle %s failed verification: %v", bundlePath, err)
+	}
+	return nil
+}
+
+// VerificationFlags are the `opa run` flags to verify the signatures of the bundle at
+// `bundlePath` with the VerificationKey, which is copied next to the bundle (whose
+// directory is mounted in the OPA container, see NewOpaContainer), so that the key itself
+// is never on the command line; the copy is returned, to be removed with the bundle.
+func (s *BundleSigning) VerificationFlags(bundlePath string) ([]string, string, error) {
+	key, err := os.ReadFile(s.VerificationKey)
+	if err != nil {
+		return nil, "", err
+	}
+	keyCopy := bundlePath + ".key"
+	if err := os.WriteFile(keyCopy, key, 0600); err != nil {
+		return nil, "", err
+	}
+	return []string{"--verification-key", filepath.Join(OpaBundleDir, filepath.Base(keyCopy)),
+		"--verification-key-id", s.KeyID, "--signing-alg", s.Algorithm}, keyCopy, nil
+}
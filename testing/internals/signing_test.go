@@ -0,0 +1,114 @@
+package internals_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/CopilotIQ/opa-tests/testing/internals"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// writeKey writes the PEM `block` of the given `kind` to a file in `dir`, and returns its path.
+func writeKey(dir string, name string, kind string, block []byte) string {
+	path := filepath.Join(dir, name)
+	Expect(os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: block}), 0600)).To(Succeed())
+	return path
+}
+
+var _ = Describe("Signed bundles", func() {
+	var dir string
+	var bundles []string
+	var manifest = filepath.Join(examplePolicies, "manifest.json")
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "signing")
+		Expect(err).ShouldNot(HaveOccurred())
+		bundles = nil
+	})
+	AfterEach(func() {
+		for _, bundle := range bundles {
+			Expect(os.Remove(bundle)).To(Succeed())
+		}
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	createBundle := func(signing *internals.BundleSigning) string {
+		bundle, err := internals.CreateSignedBundle(manifest, examplePolicies, signing)
+		Expect(err).ShouldNot(HaveOccurred())
+		bundles = append(bundles, bundle)
+		return bundle
+	}
+	secretSigning := func(alg string) *internals.BundleSigning {
+		secret := filepath.Join(dir, "secret")
+		Expect(os.WriteFile(secret, []byte("s3cr3t"), 0600)).To(Succeed())
+		signing, err := internals.NewBundleSigning(secret, "", alg, "")
+		Expect(err).ShouldNot(HaveOccurred())
+		return signing
+	}
+
+	It("have the signatures of all their files", func() {
+		signing := secretSigning("HS256")
+		bundle := createBundle(signing)
+		Expect(bundleNames(bundle)).To(ConsistOf("common.rego", "tokens.rego", "users.rego", ".manifest",
+			internals.SignaturesFile))
+		Expect(signing.VerifyBundle(bundle)).To(Succeed())
+	})
+	It("are signed with a secret", func() {
+		signing := secretSigning("HS512")
+		Expect(signing.VerificationKey).To(Equal(signing.Key))
+		Expect(signing.KeyID).To(Equal(internals.DefaultSigningKeyID))
+		bundle := createBundle(signing)
+		Expect(signing.VerifyBundle(bundle)).To(Succeed())
+
+		Expect(os.WriteFile(signing.Key, []byte("wrong"), 0600)).To(Succeed())
+		Expect(signing.VerifyBundle(bundle)).To(MatchError(ContainSubstring("failed verification")))
+	})
+	It("are signed with a private key", func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ShouldNot(HaveOccurred())
+		private, err := x509.MarshalPKCS8PrivateKey(key)
+		Expect(err).ShouldNot(HaveOccurred())
+		public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		signing, err := internals.NewBundleSigning(writeKey(dir, "private.pem", "PRIVATE KEY", private),
+			writeKey(dir, "public.pem", "PUBLIC KEY", public), "ES256", "test")
+		Expect(err).ShouldNot(HaveOccurred())
+		bundle := createBundle(signing)
+		Expect(signing.VerifyBundle(bundle)).To(Succeed())
+		flags, keyCopy, err := signing.VerificationFlags(bundle)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.Remove(keyCopy)
+		// The key is passed as the path of its copy, in the mounted bundle directory
+		Expect(flags).To(Equal([]string{"--verification-key", "/etc/opa/bundles/" + filepath.Base(bundle) + ".key",
+			"--verification-key-id", "test", "--signing-alg", "ES256"}))
+		Expect(os.ReadFile(keyCopy)).To(Equal(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public})))
+
+		// Verified with someone else's key
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ShouldNot(HaveOccurred())
+		public, err = x509.MarshalPKIXPublicKey(&other.PublicKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		writeKey(dir, "public.pem", "PUBLIC KEY", public)
+		Expect(signing.VerifyBundle(bundle)).To(MatchError(ContainSubstring("failed verification")))
+	})
+	It("fail verification if not signed", func() {
+		signing := secretSigning("HS256")
+		Expect(signing.VerifyBundle(createBundle(nil))).To(MatchError(ContainSubstring("missing .signatures.json")))
+	})
+	It("need a valid configuration", func() {
+		secret := secretSigning("HS256").Key
+		_, err := internals.NewBundleSigning(secret, "", "XX256", "")
+		Expect(err).To(MatchError(ContainSubstring("invalid signing algorithm XX256")))
+		_, err = internals.NewBundleSigning(secret, "", "RS256", "")
+		Expect(err).To(MatchError(ContainSubstring("need the public key")))
+		_, err = internals.NewBundleSigning(filepath.Join(dir, "missing"), "", "HS256", "")
+		Expect(err).To(MatchError(ContainSubstring("cannot read the signing key")))
+	})
+})
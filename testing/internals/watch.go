@@ -0,0 +1,270 @@
+package internals
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	"github.com/open-policy-agent/opa/ast"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const v1Policies = "v1/policies"
+
+// A Watcher detects the changes to the files matching its glob Patterns, by comparing
+// their modification time and size with those seen the last time it looked.
+type Watcher struct {
+	Patterns []string
+
+	files map[string]fileState
+}
+
+type fileState struct {
+	modTime int64
+	size    int64
+}
+
+// NewWatcher returns a Watcher for the files matching the glob `patterns`, which will
+// report the changes made from now on.
+func NewWatcher(patterns ...string) (*Watcher, error) {
+	w := &Watcher{Patterns: patterns}
+	var err error
+	w.files, err = w.scan()
+	return w, err
+}
+
+func (w *Watcher) scan() (map[string]fileState, error) {
+	var files = make(map[string]fileState)
+	for _, pattern := range w.Patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			files[match] = fileState{modTime: info.ModTime().UnixNano(), size: info.Size()}
+		}
+	}
+	return files, nil
+}
+
+// Changes returns the files which were created, modified or removed since the last time
+// it was called (or since the Watcher was created), sorted.
+func (w *Watcher) Changes() ([]string, error) {
+	files, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	for file, state := range files {
+		if previous, found := w.files[file]; !found || previous != state {
+			changed = append(changed, file)
+		}
+	}
+	for file := range w.files {
+		if _, found := files[file]; !found {
+			changed = append(changed, file)
+		}
+	}
+	w.files = files
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// PutPolicies creates, or updates, the policies in the Rego `files` on the OPA server at
+// `addr` (via the Policy API), using their paths as IDs; the files which no longer exist
+// are deleted from the server, and those which are not policies (e.g., the Rego test
+// modules, or Testcases) are ignored.
+func PutPolicies(addr string, files []string) error {
+	var uploads []policyChange
+	var deletes []policyChange
+	for _, file := range files {
+		if matched, _ := filepath.Match(PoliciesGlob, filepath.Base(file)); !matched || isRegoTest(file) {
+			continue
+		}
+		source, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			deletes = append(deletes, policyChange{method: http.MethodDelete, id: policyId(file)})
+			continue
+		} else if err != nil {
+			return err
+		}
+		uploads = append(uploads, policyChange{method: http.MethodPut, id: policyId(file), source: source})
+	}
+	return applyPolicyChanges(addr, append(uploads, deletes...))
+}
+
+// ReplacePolicies replaces all the policies on the OPA server at `addr` with those in the
+// Rego `files` (ignoring, as PutPolicies, the Rego test modules): those which are not among
+// them (e.g., those whose files were removed) are deleted from the server.
+func ReplacePolicies(addr string, files []string) error {
+	var sources = make(map[string][]byte)
+	for _, file := range files {
+		if matched, _ := filepath.Match(PoliciesGlob, filepath.Base(file)); !matched || isRegoTest(file) {
+			continue
+		}
+		source, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		sources[policyId(file)] = source
+	}
+	return replacePolicies(addr, sources)
+}
+
+// A policyChange is a request to the Policy API, to upload (PUT) or DELETE a policy.
+type policyChange struct {
+	method string
+	id     string
+	source []byte
+}
+
+// applyPolicyChanges sends the `changes`, in order, to the OPA server at `addr`.
+//
+// As OPA compiles the policies on every change, those which fail (e.g., the policies
+// depending on others not yet uploaded, or those still used by others, which cannot be
+// deleted yet) are retried, until all succeed, or none of the remaining ones can.
+func applyPolicyChanges(addr string, changes []policyChange) error {
+	for len(changes) > 0 {
+		var failed []policyChange
+		var lastErr error
+		for _, change := range changes {
+			if err := policyRequest(change.method, addr, change.id, change.source); err != nil {
+				failed = append(failed, change)
+				lastErr = err
+			}
+		}
+		if len(failed) == len(changes) {
+			return lastErr
+		}
+		changes = failed
+	}
+	return nil
+}
+
+// PutPolicy creates, or updates, the policy in the Rego `file` on the OPA server at `addr`.
+func PutPolicy(addr string, file string) error {
+	source, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return policyRequest(http.MethodPut, addr, policyId(file), source)
+}
+
+// DeletePolicy deletes the policy uploaded from the Rego `file` from the OPA server at `addr`;
+// it is not an error if it was never uploaded.
+func DeletePolicy(addr string, file string) error {
+	return policyRequest(http.MethodDelete, addr, policyId(file), nil)
+}
+
+// policyId is the ID of the policy uploaded from the Rego `file`.
+func policyId(file string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(file)), UrlSep)
+}
+
+func policyRequest(method string, addr string, id string, source []byte) error {
+	url := fmt.Sprintf("http://%s/%s/%s", addr, v1Policies, id)
+	req, err := http.NewRequest(method, url, bytes.NewReader(source))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK || (method == http.MethodDelete && resp.StatusCode == http.StatusNotFound) {
+		return nil
+	}
+	return fmt.Errorf("cannot %s policy %s: %s", method, id, opaError(resp))
+}
+
+// opaError returns the message (and errors) of an OPA server error response.
+func opaError(resp *http.Response) string {
+	body, _ := io.ReadAll(resp.Body)
+	var response struct {
+		Message string `json:"message"`
+		Errors  []struct {
+			Message  string `json:"message"`
+			Location *struct {
+				File string `json:"file"`
+				Row  int    `json:"row"`
+			} `json:"location"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil || response.Message == "" {
+		return resp.Status
+	}
+	message := response.Message
+	for _, e := range response.Errors {
+		if e.Location != nil {
+			message += fmt.Sprintf("\n%s:%d: %s", e.Location.File, e.Location.Row, e.Message)
+		} else {
+			message += "\n" + e.Message
+		}
+	}
+	return message
+}
+
+// AffectedTests returns the `tests` which may be affected by changes to the `changed` files:
+// those defined in a changed Testcase, and those whose target rules (or any of the rules
+// they depend upon) are defined in a changed Rego file.
+func AffectedTests(policies *Policies, tests []TestUnit, changed []string) []TestUnit {
+	var changedFiles = make(map[string]bool, len(changed))
+	for _, file := range changed {
+		changedFiles[filepath.Clean(file)] = true
+	}
+	var affectedEndpoints = make(map[string]bool)
+	var affected []TestUnit
+	for _, test := range tests {
+		isAffected, found := affectedEndpoints[test.Endpoint]
+		if !found {
+			for file := range policies.Files(test.Endpoint) {
+				if changedFiles[file] {
+					isAffected = true
+					break
+				}
+			}
+			affectedEndpoints[test.Endpoint] = isAffected
+		}
+		if isAffected || changedFiles[filepath.Clean(test.File)] {
+			affected = append(affected, test)
+		}
+	}
+	return affected
+}
+
+// Files returns the Rego files which define the rules at the `endpoint`, and all the
+// rules they (transitively) depend upon.
+func (p *Policies) Files(endpoint string) map[string]bool {
+	var files = make(map[string]bool)
+	var visited = make(map[*ast.Rule]bool)
+	var visit func(rule *ast.Rule)
+	visit = func(rule *ast.Rule) {
+		if visited[rule] {
+			return
+		}
+		visited[rule] = true
+		if rule.Location != nil {
+			files[filepath.Clean(rule.Location.File)] = true
+		}
+		for dependency := range p.Compiler.Graph.Dependencies(rule) {
+			if r, ok := dependency.(*ast.Rule); ok {
+				visit(r)
+			}
+		}
+	}
+	for _, rule := range p.Compiler.GetRulesWithPrefix(EndpointRef(endpoint)) {
+		visit(rule)
+	}
+	return files
+}
@@ -0,0 +1,188 @@
+package internals_test
+
+import (
+	"encoding/json"
+	"github.com/CopilotIQ/opa-tests/testing"
+	"github.com/CopilotIQ/opa-tests/testing/internals"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakePolicyApi returns a server which records the policies uploaded via the Policy API,
+// and rejects those whose source contains `reject`, or `import data.common` before
+// the `common` package is uploaded (or its deletion, while it is still imported).
+func fakePolicyApi(policies map[string]string, mutex *sync.Mutex) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		id := strings.TrimPrefix(r.URL.Path, "/v1/policies/")
+		switch r.Method {
+		case http.MethodGet:
+			var list []map[string]string
+			for id, source := range policies {
+				list = append(list, map[string]string{"id": id, "raw": source})
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": list})
+			return
+		case http.MethodPut:
+			source, _ := io.ReadAll(r.Body)
+			var hasCommon bool
+			for _, policy := range policies {
+				hasCommon = hasCommon || policy == "package common"
+			}
+			if strings.Contains(string(source), "reject") ||
+				(strings.Contains(string(source), "import data.common") && !hasCommon) {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"code": "invalid_parameter", "message": "error(s) occurred while compiling module(s)",
+"errors": [{"message": "rego_type_error: undefined function", "location": {"file": "` + id + `", "row": 3}}]}`))
+				return
+			}
+			policies[id] = string(source)
+		case http.MethodDelete:
+			if _, found := policies[id]; !found {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			for _, policy := range policies {
+				if policies[id] == "package common" && strings.Contains(policy, "import data.common") {
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = w.Write([]byte(`{"code": "invalid_parameter", "message": "error(s) occurred while compiling module(s)"}`))
+					return
+				}
+			}
+			delete(policies, id)
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+}
+
+var _ = Describe("Watch", func() {
+	var dir string
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "watch")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("detects the created, modified and removed files", func() {
+		a, b, c := filepath.Join(dir, "a.rego"), filepath.Join(dir, "b.rego"), filepath.Join(dir, "c.rego")
+		Expect(os.WriteFile(a, []byte("package a"), 0600)).To(Succeed())
+		Expect(os.WriteFile(b, []byte("package b"), 0600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0600)).To(Succeed())
+		watcher, err := internals.NewWatcher(filepath.Join(dir, "*.rego"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(watcher.Changes()).To(BeEmpty())
+
+		Expect(os.WriteFile(a, []byte("package a.changed"), 0600)).To(Succeed())
+		Expect(os.Remove(b)).To(Succeed())
+		Expect(os.WriteFile(c, []byte("package c"), 0600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("still ignored"), 0600)).To(Succeed())
+		Expect(watcher.Changes()).To(Equal([]string{a, b, c}))
+		Expect(watcher.Changes()).To(BeEmpty())
+
+		later := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(c, later, later)).To(Succeed())
+		Expect(watcher.Changes()).To(Equal([]string{c}))
+	})
+	It("uploads the changed policies, retrying those depending on others", func() {
+		var mutex sync.Mutex
+		uploaded := map[string]string{}
+		server := fakePolicyApi(uploaded, &mutex)
+		defer server.Close()
+		addr := strings.TrimPrefix(server.URL, "http://")
+
+		policy := filepath.Join(dir, "policy.rego")
+		Expect(os.WriteFile(policy, []byte("package copilotiq"), 0600)).To(Succeed())
+		test := filepath.Join(dir, "policy_test.rego")
+		Expect(os.WriteFile(test, []byte("package copilotiq"), 0600)).To(Succeed())
+		Expect(internals.PutPolicies(addr, []string{policy, test, filepath.Join(dir, "tests.yaml")})).To(Succeed())
+		Expect(uploaded).To(Equal(map[string]string{filepath.ToSlash(policy)[1:]: "package copilotiq"}))
+
+		Expect(os.Remove(policy)).To(Succeed())
+		Expect(internals.PutPolicies(addr, []string{policy, filepath.Join(dir, "never.rego")})).To(Succeed())
+		Expect(uploaded).To(BeEmpty())
+
+		users, common := filepath.Join(dir, "a_users.rego"), filepath.Join(dir, "common.rego")
+		Expect(os.WriteFile(users, []byte("package users\nimport data.common"), 0600)).To(Succeed())
+		Expect(os.WriteFile(common, []byte("package common"), 0600)).To(Succeed())
+		Expect(internals.PutPolicies(addr, []string{users, common})).To(Succeed())
+		Expect(uploaded).To(HaveLen(2))
+
+		broken := filepath.Join(dir, "broken.rego")
+		Expect(os.WriteFile(broken, []byte("package copilotiq\nreject"), 0600)).To(Succeed())
+		err := internals.PutPolicies(addr, []string{broken})
+		Expect(err).Should(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("broken.rego:3: rego_type_error: undefined function"))
+	})
+	It("replaces all the policies, deleting those whose files were removed", func() {
+		var mutex sync.Mutex
+		uploaded := map[string]string{"removed/common.rego": "package common",
+			"removed/users.rego": "package users\nimport data.common"}
+		server := fakePolicyApi(uploaded, &mutex)
+		defer server.Close()
+
+		policy := filepath.Join(dir, "policy.rego")
+		Expect(os.WriteFile(policy, []byte("package copilotiq"), 0600)).To(Succeed())
+		test := filepath.Join(dir, "policy_test.rego")
+		Expect(os.WriteFile(test, []byte("package copilotiq"), 0600)).To(Succeed())
+		Expect(internals.ReplacePolicies(strings.TrimPrefix(server.URL, "http://"), []string{policy, test})).
+			To(Succeed())
+		Expect(uploaded).To(Equal(map[string]string{filepath.ToSlash(policy)[1:]: "package copilotiq"}))
+	})
+	It("replaces the policies with those in the bundle", func() {
+		var mutex sync.Mutex
+		uploaded := map[string]string{"old/common.rego": "package common",
+			"old/users.rego": "package users\nimport data.common"}
+		server := fakePolicyApi(uploaded, &mutex)
+		defer server.Close()
+		Expect(internals.PushBundle(strings.TrimPrefix(server.URL, "http://"), testBundle)).To(Succeed())
+		Expect(uploaded).To(HaveLen(len(testPolicies)))
+		for _, policy := range testPolicies {
+			Expect(uploaded[policy]).To(HavePrefix("#"))
+		}
+	})
+	It("checks that the bundle's policies were pushed", func() {
+		// A server which accepts the policies, but never has any
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"result": []}`))
+		}))
+		defer server.Close()
+		err := internals.PushBundle(strings.TrimPrefix(server.URL, "http://"), testBundle)
+		Expect(err).To(MatchError(ContainSubstring("is missing on the OPA server")))
+	})
+	It("selects the tests affected by the changes", func() {
+		policies, err := internals.LoadPolicies(examplePolicies)
+		Expect(err).ShouldNot(HaveOccurred())
+		tests, err := testing.Generate("../../testdata/testcases")
+		Expect(err).ShouldNot(HaveOccurred())
+		names := func(tests []testing.TestUnit) []string {
+			var names []string
+			for _, test := range tests {
+				names = append(names, test.Name)
+			}
+			return names
+		}
+
+		Expect(names(internals.AffectedTests(policies, tests, []string{
+			filepath.Join(examplePolicies, "users.rego")}))).To(Equal([]string{"Overrides.default_target"}))
+		Expect(names(internals.AffectedTests(policies, tests, []string{
+			filepath.Join(examplePolicies, "common.rego")}))).To(Equal([]string{
+			"Overrides.default_target", "Overrides.override_package"}))
+		Expect(internals.AffectedTests(policies, tests, []string{"../../testdata/testcases/overrides.yaml"})).
+			To(HaveLen(3))
+		Expect(internals.AffectedTests(policies, tests, []string{"other.yaml"})).To(BeEmpty())
+		Expect(policies.Files("copilotiq/allow")).To(HaveLen(3))
+	})
+})
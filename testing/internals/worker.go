@@ -11,6 +11,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -25,46 +26,115 @@ func fullUrl(url string, endpoint string) string {
 
 func SendData(serverURL string, dataChan <-chan TestUnit, report *TestReport) error {
 	for testUnit := range dataChan {
+		if testUnit.Skip != "" {
+			report.ReportSkipped(testUnit.Name, testUnit.Skip)
+			continue
+		}
 		jsonData, err := json.Marshal(testUnit.Body)
 		if err != nil {
 			return err
 		}
 
+		start := time.Now()
 		resp, err := http.Post(fullUrl(serverURL, testUnit.Endpoint), contentType,
 			bytes.NewBuffer(jsonData))
 		if err != nil {
 			return err
 		}
 
+		result := &TestResult{
+			Name:     testUnit.Name,
+			Testcase: testUnit.Testcase,
+			File:     testUnit.File,
+			Line:     testUnit.Line,
+			Endpoint: testUnit.Endpoint,
+			Expected: testUnit.Expectation,
+			Body:     &testUnit.Body,
+			Duration: time.Since(start),
+		}
 		if resp.StatusCode != http.StatusOK {
-			report.ReportFailure(testUnit.Name)
+			result.Error = fmt.Sprintf("OPA server returned %s", resp.Status)
+			report.ReportFailure(result)
 			resp.Body.Close()
 			continue
 		}
-		b, err := GetResult(resp.Body)
+		response, err := decodeResponse(resp.Body)
 		resp.Body.Close()
-		if err != nil || b != testUnit.Expectation {
-			report.ReportFailure(testUnit.Name)
+		var b bool
+		if err == nil {
+			result.Actual, result.DecisionID = response.Result, response.DecisionID
+			b, err = asBool(result.Actual)
+		}
+		if err != nil {
+			result.Error = err.Error()
+			report.ReportFailure(result)
+		} else if b != testUnit.Expectation {
+			report.ReportFailure(result)
 		} else {
-			report.IncSuccess()
+			report.ReportSuccess(result)
 		}
-		fmt.Print(".")
 	}
 	return nil
 }
 
-func GetResult(r io.Reader) (bool, error) {
+// Query evaluates the rule at `endpoint` against the `input` on the OPA server at `serverURL`,
+// and returns its result, or nil if the result is undefined.
+func Query(serverURL string, endpoint string, input interface{}) (interface{}, error) {
+	jsonData, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.Post(fullUrl(serverURL, endpoint), contentType, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OPA server returned %s", resp.Status)
+	}
+	return GetResponse(resp.Body)
+}
+
+// An opaResponse is the response of the OPA server Data API; the DecisionID is only
+// returned if decision logging is enabled.
+type opaResponse struct {
+	Result     interface{} `json:"result"`
+	DecisionID string      `json:"decision_id"`
+}
+
+func decodeResponse(r io.Reader) (*opaResponse, error) {
 	body, err := io.ReadAll(r)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	var response opaResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// GetResponse decodes the OPA server response, and returns its `result`, or nil
+// if the result is undefined.
+func GetResponse(r io.Reader) (interface{}, error) {
+	response, err := decodeResponse(r)
+	if err != nil {
+		return nil, err
 	}
-	var responseData map[string]interface{}
-	if err := json.Unmarshal(body, &responseData); err != nil {
+	return response.Result, nil
+}
+
+func GetResult(r io.Reader) (bool, error) {
+	result, err := GetResponse(r)
+	if err != nil {
 		return false, err
 	}
-	result, ok := responseData["result"]
-	if !ok {
-		return false, fmt.Errorf("nothing to show")
+	return asBool(result)
+}
+
+func asBool(result interface{}) (bool, error) {
+	if result == nil {
+		return false, fmt.Errorf(UndefinedResult)
 	}
 	b, ok := result.(bool)
 	if !ok {
@@ -82,7 +152,9 @@ func EstimateWorkers() uint {
 	return 1
 }
 
-func RunTests(tests []TestUnit, workers uint, addr string) *TestReport {
+// RunTests sends all the `tests` to the OPA server at `addr`, using `workers` parallel
+// goroutines; `progress` (if not nil) is called as each of the results is reported.
+func RunTests(tests []TestUnit, workers uint, addr string, progress func(*TestResult)) *TestReport {
 	dataChan := make(chan TestUnit)
 	var wg sync.WaitGroup
 	if workers == 0 {
@@ -94,7 +166,7 @@ func RunTests(tests []TestUnit, workers uint, addr string) *TestReport {
 		Log.Warn("running single-core, execution will be slower")
 	}
 	url := fmt.Sprintf("http://%s", addr)
-	var report TestReport
+	var report = TestReport{Progress: progress}
 	for i := uint(0); i < workers; i++ {
 		wg.Add(1)
 		go func(num uint) {
@@ -107,12 +179,11 @@ func RunTests(tests []TestUnit, workers uint, addr string) *TestReport {
 			Log.Debug("worker #%d done", num)
 		}(i)
 	}
-	for _, req := range tests {
+	for _, req := range Focus(tests) {
 		dataChan <- req
 	}
 	// Once you're done sending data, close the channel
 	close(dataChan)
 	wg.Wait()
-	fmt.Println()
 	return &report
 }
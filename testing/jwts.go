@@ -26,3 +26,12 @@ func NewToken(body *JwtBody) string {
 	ss, _ := token.SignedString(SecretKey)
 	return ss
 }
+
+// DecodeClaims returns the claims in the `token`, without verifying its signature.
+func DecodeClaims(token string) (map[string]interface{}, error) {
+	var claims = jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
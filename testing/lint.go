@@ -0,0 +1,265 @@
+// Copyright (c) 2022 CopilotIQ Inc.  All rights reserved
+
+package testing
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Problem is an issue found while validating a Testcase YAML file,
+// at the given (1-based) Line and Column.
+type Problem struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, p.Message)
+}
+
+// LintError collects all the Problems found in a Testcase, it is returned by
+// ReadTestcase when the YAML is not a valid Testcase.
+type LintError []Problem
+
+func (e LintError) Error() string {
+	var lines = make([]string, len(e))
+	for i, p := range e {
+		lines[i] = p.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// The yaml.v3 errors carry the position as a `line N: ` prefix
+var yamlErrorLine = regexp.MustCompile(`line (\d+): (.*)`)
+
+// Lint validates the Testcase YAML at `path` and returns all the Problems found;
+// the error is only returned if the file could not be read.
+func Lint(path string) ([]Problem, error) {
+	_, problems, err := parseTestcase(path)
+	return problems, err
+}
+
+func parseTestcase(path string) (*Testcase, []Problem, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(contents, &root); err != nil {
+		return nil, []Problem{yamlProblem(path, err.Error())}, nil
+	}
+	testcase, problems := lintTestcase(path, &root)
+	return testcase, problems, nil
+}
+
+// lintTestcase decodes the Testcase from the `root` YAML document, and
+// collects all the Problems with it.
+func lintTestcase(path string, root *yaml.Node) (*Testcase, []Problem) {
+	l := linter{file: path}
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 {
+		l.report(root, "empty document, a `testcase` is required")
+		return nil, l.problems
+	}
+	doc := root.Content[0]
+	l.checkKeys(doc, reflect.TypeOf(TestcaseTemplate{}), "")
+
+	var template TestcaseTemplate
+	if err := root.Decode(&template); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			for _, msg := range typeErr.Errors {
+				l.problems = append(l.problems, yamlProblem(path, msg))
+			}
+		} else {
+			l.problems = append(l.problems, yamlProblem(path, err.Error()))
+		}
+		return nil, l.problems
+	}
+	testcase := valueOf(doc, "testcase")
+	if testcase == nil {
+		l.report(doc, "missing required field `testcase`")
+		return nil, l.problems
+	}
+	l.checkTestcase(testcase, &template.Body)
+	setPositions(path, testcase, &template.Body)
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		return l.problems[i].Line < l.problems[j].Line
+	})
+	return &template.Body, l.problems
+}
+
+func yamlProblem(path string, msg string) Problem {
+	msg = strings.TrimPrefix(msg, "yaml: ")
+	if m := yamlErrorLine.FindStringSubmatch(msg); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return Problem{File: path, Line: line, Column: 1, Message: m[2]}
+	}
+	return Problem{File: path, Line: 1, Column: 1, Message: msg}
+}
+
+type linter struct {
+	file     string
+	problems []Problem
+}
+
+func (l *linter) report(node *yaml.Node, format string, args ...interface{}) {
+	l.problems = append(l.problems, Problem{
+		File:    l.file,
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// checkKeys walks the YAML `node` alongside the Go type it will be decoded into,
+// and reports all the keys which do not match any of the `yaml` fields of the
+// structs: typos would otherwise be silently ignored.
+func (l *linter) checkKeys(node *yaml.Node, t reflect.Type, path string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := yamlFields(t)
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				field, found := fields[key.Value]
+				if !found {
+					l.report(key, "unknown field `%s` in `%s`", key.Value, pathOrRoot(path))
+					continue
+				}
+				l.checkKeys(value, field.Type, joinPath(path, key.Value))
+			}
+		case reflect.Map:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				l.checkKeys(node.Content[i+1], t.Elem(), joinPath(path, node.Content[i].Value))
+			}
+		}
+	case yaml.SequenceNode:
+		if t.Kind() == reflect.Slice {
+			for i, item := range node.Content {
+				l.checkKeys(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	}
+}
+
+// checkTestcase verifies that all the required fields are present, that each Test
+// has a Target, that skipped tests have a reason and that there are no duplicate names.
+func (l *linter) checkTestcase(node *yaml.Node, testcase *Testcase) {
+	l.required(node, "testcase", "name", "tests")
+	l.skipReason(node, "testcase")
+	tests := valueOf(node, "tests")
+	if tests == nil {
+		return
+	}
+	if len(testcase.Tests) == 0 {
+		l.report(tests, "`testcase.tests` cannot be empty")
+	}
+	var names = make(map[string]int)
+	for i, test := range testcase.Tests {
+		testNode := tests.Content[i]
+		path := fmt.Sprintf("testcase.tests[%d]", i)
+		l.required(testNode, path, "name", "expect")
+		l.skipReason(testNode, path)
+		if test.Name != "" {
+			if line, found := names[test.Name]; found {
+				l.report(valueOf(testNode, "name"), "duplicate test name `%s` (first defined at line %d)",
+					test.Name, line)
+			} else {
+				names[test.Name] = testNode.Line
+			}
+		}
+		target := testcase.Target.Override(test.Target)
+		if target.Package == "" || target.Policy == "" {
+			l.report(testNode, "`%s` has no target `package` and `policy`, "+
+				"either in the testcase or the test", path)
+		}
+	}
+}
+
+// setPositions records in the `testcase` the file it was read from, and the line of each Test.
+func setPositions(path string, node *yaml.Node, testcase *Testcase) {
+	testcase.File = path
+	if tests := valueOf(node, "tests"); tests != nil {
+		for i := range testcase.Tests {
+			if i < len(tests.Content) {
+				testcase.Tests[i].Line = tests.Content[i].Line
+			}
+		}
+	}
+}
+
+func (l *linter) required(node *yaml.Node, path string, fields ...string) {
+	for _, field := range fields {
+		if valueOf(node, field) == nil {
+			l.report(node, "missing required field `%s`", joinPath(path, field))
+		}
+	}
+}
+
+// skipReason reports a `skip` field without a reason, as it would be ignored.
+func (l *linter) skipReason(node *yaml.Node, path string) {
+	if skip := valueOf(node, "skip"); skip != nil && skip.Value == "" {
+		l.report(skip, "`%s` needs a reason to skip", joinPath(path, "skip"))
+	}
+}
+
+// valueOf returns the value associated with `key` in the `node` mapping, or nil if
+// the key is missing (or `node` is not a mapping).
+func valueOf(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// yamlFields maps the YAML keys to the fields of the `t` struct, following the
+// same conventions as the yaml.v3 decoder.
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	var fields = make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "."
+	}
+	return path
+}
@@ -0,0 +1,99 @@
+package testing_test
+
+import (
+	"encoding/json"
+	. "github.com/CopilotIQ/opa-tests/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+var _ = Describe("Lint", func() {
+	var invalid = filepath.Join(lintDir, "invalid.yaml")
+
+	It("finds no problems in valid Testcases", func() {
+		files, _ := filepath.Glob(filepath.Join(examplesDir, YamlGlob))
+		Expect(files).ToNot(BeEmpty())
+		for _, file := range files {
+			problems, err := Lint(file)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(problems).To(BeEmpty())
+		}
+	})
+	It("reports all problems, with their position", func() {
+		problems, err := Lint(invalid)
+		Expect(err).ShouldNot(HaveOccurred())
+		var messages []string
+		for _, p := range problems {
+			messages = append(messages, p.String())
+		}
+		Expect(messages).To(ConsistOf(
+			invalid+":14:7: unknown field `expected` in `testcase.tests[0]`",
+			invalid+":17:9: unknown field `method` in `testcase.tests[0].token`",
+			invalid+":13:7: missing required field `testcase.tests[0].expect`",
+			invalid+":13:7: `testcase.tests[0]` has no target `package` and `policy`, "+
+				"either in the testcase or the test",
+			invalid+":22:13: duplicate test name `typo_in_expect` (first defined at line 13)",
+		))
+	})
+	It("fails to read an invalid Testcase", func() {
+		_, err := ReadTestcase(invalid)
+		Expect(err).Should(HaveOccurred())
+		Expect(err).To(BeAssignableToTypeOf(LintError{}))
+	})
+	It("reports malformed YAML", func() {
+		problems, err := Lint(filepath.Join(lintDir, "malformed.yaml"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(problems).To(HaveLen(1))
+		Expect(problems[0].Line).To(Equal(3))
+	})
+})
+
+var _ = Describe("The Testcase JSON Schema", func() {
+	var defs map[string]interface{}
+	BeforeEach(func() {
+		contents, err := os.ReadFile("../schema/testcase.schema.json")
+		Expect(err).ShouldNot(HaveOccurred())
+		var schema map[string]interface{}
+		Expect(json.Unmarshal(contents, &schema)).To(Succeed())
+		defs = schema["$defs"].(map[string]interface{})
+		defs["testcase"] = schema["properties"].(map[string]interface{})["testcase"]
+	})
+	DescribeTable("matches the YAML fields of",
+		func(def string, v interface{}) {
+			properties := defs[def].(map[string]interface{})["properties"].(map[string]interface{})
+			var names []string
+			for name := range properties {
+				names = append(names, name)
+			}
+			var fields []string
+			t := reflect.TypeOf(v)
+			for i := 0; i < t.NumField(); i++ {
+				name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+				if name == "-" {
+					continue
+				}
+				if name == "" {
+					name = strings.ToLower(t.Field(i).Name)
+				}
+				fields = append(fields, name)
+			}
+			Expect(names).To(ConsistOf(fields))
+		},
+		Entry("Testcase", "testcase", Testcase{}),
+		Entry("Test", "test", Test{}),
+		Entry("Target", "target", Target{}),
+		Entry("JwtBody", "token", JwtBody{}),
+		Entry("Resource", "resource", Resource{}),
+	)
+	It("requires a target, either on the Testcase or on each Test", func() {
+		anyOf := defs["testcase"].(map[string]interface{})["anyOf"].([]interface{})
+		Expect(anyOf).To(ContainElement(HaveKeyWithValue("required", ConsistOf("target"))))
+		tests := anyOf[1].(map[string]interface{})["properties"].(map[string]interface{})["tests"]
+		Expect(tests).To(HaveKeyWithValue("items", HaveKeyWithValue("required", ConsistOf("target"))))
+	})
+})
@@ -6,13 +6,15 @@ package testing
 
 import (
 	"github.com/massenz/slf4go/logging"
+	"os"
 	"path/filepath"
 	"strings"
 )
 
 const (
-	YamlGlob     = "*.yaml"
-	PoliciesGlob = "*.rego"
+	YamlGlob      = "*.yaml"
+	PoliciesGlob  = "*.rego"
+	RegoTestsGlob = "*_test.rego"
 )
 
 var Log = logging.NewLog("testgen")
@@ -25,13 +27,26 @@ func NewRequest(t *Test) Request {
 	}
 }
 
-// Generate all the test cases from the `SourceDir`
+// TestcaseFiles returns the YAML Testcase files in the `path` directory; if `path`
+// is a file, it is returned as the only Testcase.
+func TestcaseFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	// TODO: walk the subtree (instead of just the directory) and modify the test names to
+	// 		 reflect the position in the subtree using WalkDir(root string, fn fs.WalkDirFunc)
+	return filepath.Glob(filepath.Join(path, YamlGlob))
+}
+
+// Generate all the test cases from the `SourceDir` (or the single Testcase file)
 func Generate(SourceDir string) ([]TestUnit, error) {
 	Log.Debug("Generating test requests from %s", SourceDir)
 
-	// TODO: walk the subtree (instead of just the directory) and modify the test names to
-	// 		 reflect the position in the subtree using WalkDir(root string, fn fs.WalkDirFunc)
-	files, err := filepath.Glob(filepath.Join(SourceDir, YamlGlob))
+	files, err := TestcaseFiles(SourceDir)
 	if err != nil {
 		return nil, err
 	}
@@ -44,23 +59,63 @@ func Generate(SourceDir string) ([]TestUnit, error) {
 			Log.Error("could not read YAML %s: %s", file, err)
 			return nil, err
 		}
-		endpoint := strings.Join([]string{testcase.Target.Package, testcase.Target.Policy}, "/")
-		Log.Debug("%s === %s", file, testcase.Name, endpoint)
+		Log.Debug("%s === %s (%s)", file, testcase.Name, testcase.Target.Endpoint())
 		for _, test := range testcase.Tests {
 			testname := strings.Join([]string{testcase.Name, test.Name}, ".")
-			Log.Debug("--- %s", testname)
+			endpoint := testcase.Target.Override(test.Target).Endpoint()
+			Log.Debug("--- %s -> %s (line %d)", testname, endpoint, test.Line)
 			Log.Trace("JWT contents: %v", test.Token)
 			if test.Token.Issuer == "" {
 				test.Token.Issuer = testcase.Iss
 			}
+			skip := test.Skip
+			if skip == "" {
+				skip = testcase.Skip
+			}
 			requests = append(requests, TestUnit{
 				Name:        testname,
+				Testcase:    testcase.Name,
+				File:        testcase.File,
+				Line:        test.Line,
 				Endpoint:    endpoint,
 				Body:        TestBody{Input: NewRequest(&test)},
 				Expectation: test.Expect,
+				Tags:        append(append([]string{}, testcase.Tags...), test.Tags...),
+				Skip:        skip,
+				Only:        test.Only || testcase.Only,
 			})
 		}
 	}
 	Log.Info("Generated %d tests", len(requests))
 	return requests, nil
 }
+
+// NotFocusedReason is the reason reported for the tests skipped because others were marked `only`
+const NotFocusedReason = "not focused (other tests are marked `only`)"
+
+// Focus marks as skipped all the `tests` not marked `Only`, if there is at least one
+// which is; otherwise, the tests are returned unchanged.
+func Focus(tests []TestUnit) []TestUnit {
+	if len(Focused(tests)) == 0 {
+		return tests
+	}
+	var focused = make([]TestUnit, len(tests))
+	for i, test := range tests {
+		if !test.Only && test.Skip == "" {
+			test.Skip = NotFocusedReason
+		}
+		focused[i] = test
+	}
+	return focused
+}
+
+// Focused returns the names of the `tests` marked `Only`.
+func Focused(tests []TestUnit) []string {
+	var names []string
+	for _, test := range tests {
+		if test.Only {
+			names = append(names, test.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,81 @@
+package testing_test
+
+import (
+	. "github.com/CopilotIQ/opa-tests/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test Generator", func() {
+
+	When("generating tests from the examples", func() {
+		It("should use the Testcase target", func() {
+			tests, err := Generate(examplesDir)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(tests).To(HaveLen(12))
+			for _, test := range tests {
+				Expect(test.Endpoint).To(Equal("copilotiq/allow"))
+			}
+		})
+	})
+
+	When("a Test has its own target", func() {
+		var tests []TestUnit
+		BeforeEach(func() {
+			var err error
+			tests, err = Generate(testcasesDir)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("should keep the Testcase target if not overridden", func() {
+			Expect(tests[0].Name).To(Equal("Overrides.default_target"))
+			Expect(tests[0].Endpoint).To(Equal("copilotiq/allow"))
+		})
+		It("can override only the policy", func() {
+			Expect(tests[1].Endpoint).To(Equal("copilotiq/audit_required"))
+		})
+		It("can override the package", func() {
+			Expect(tests[2].Endpoint).To(Equal("copilotiq/common/is_admin"))
+		})
+		It("tracks the position of each test", func() {
+			Expect(tests[0].Testcase).To(Equal("Overrides"))
+			Expect(tests[0].Position()).To(Equal(testcasesDir + "/overrides.yaml:17"))
+			Expect(tests[1].Line).To(Equal(27))
+			Expect(tests[2].Line).To(Equal(41))
+		})
+	})
+})
+
+var _ = Describe("Target", func() {
+	It("maps dotted packages to the data API path", func() {
+		t := Target{Package: "com.example.users", Policy: "grant"}
+		Expect(t.Endpoint()).To(Equal("com/example/users/grant"))
+	})
+	It("is unchanged by a nil override", func() {
+		t := Target{Package: "example", Policy: "allow"}
+		Expect(t.Override(nil)).To(Equal(t))
+	})
+})
+
+var _ = Describe("Focus", func() {
+	var tests []TestUnit
+	BeforeEach(func() {
+		var err error
+		tests, err = Generate(focusDir)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	It("carries the markers", func() {
+		Expect(tests[0].Only).To(BeTrue())
+		Expect(tests[1].Skip).To(Equal("ENG-123: known to be broken"))
+		Expect(Focused(tests)).To(Equal([]string{"Focus.focused"}))
+	})
+	It("skips the tests not marked only", func() {
+		focused := Focus(tests)
+		Expect(focused[0].Skip).To(BeEmpty())
+		Expect(focused[1].Skip).To(Equal("ENG-123: known to be broken"))
+		Expect(focused[2].Skip).To(Equal(NotFocusedReason))
+	})
+	It("leaves the tests unchanged if none is marked only", func() {
+		tests[0].Only = false
+		Expect(Focus(tests)).To(Equal(tests))
+	})
+})
@@ -0,0 +1,45 @@
+package testing_test
+
+import (
+	. "github.com/CopilotIQ/opa-tests/testing"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	testcasesDir = "../testdata/testcases"
+	examplesDir  = "../examples/tests"
+	lintDir      = "../testdata/lint"
+	focusDir     = "../testdata/focus"
+)
+
+func TestTesting(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Testing Suite")
+}
+
+// reportFixture returns the tests in the testcasesDir, and their report: the first one
+// passed, the second failed (expecting true) and the third was skipped ("not ready").
+//
+// The report's `progress` (if not nil) is called as they are reported, and `results` (if
+// not nil) can first add the details of the passed and failed results.
+func reportFixture(progress func(*TestResult), results func(passed *TestResult, failed *TestResult)) (
+	[]TestUnit, *TestReport) {
+	tests, err := Generate(testcasesDir)
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(tests).To(HaveLen(3))
+	passed := &TestResult{Name: tests[0].Name, Testcase: tests[0].Testcase, Endpoint: tests[0].Endpoint,
+		Body: &tests[0].Body, Expected: true, Actual: true}
+	failed := &TestResult{Name: tests[1].Name, Testcase: tests[1].Testcase, Endpoint: tests[1].Endpoint,
+		Body: &tests[1].Body, Expected: true, Actual: false}
+	if results != nil {
+		results(passed, failed)
+	}
+	report := &TestReport{Progress: progress}
+	report.ReportSuccess(passed)
+	report.ReportFailure(failed)
+	report.ReportSkipped(tests[2].Name, "not ready")
+	return tests, report
+}
@@ -4,7 +4,12 @@
 
 package testing
 
-import "sync"
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
 
 // A BundleManifest describes the `Bundle` to the OPA server
 // We only use it for informational purposes during tests execution.
@@ -63,23 +68,59 @@ type Target struct {
 	// Package matches the Rego module `package` keyword
 	Package string `yaml:"package"`
 
-	// The Policy matches the Rego module rule that we are testing; there
-	// can only be one Policy per Testcase, hence to test different rules
-	// in the same Package, you will need to create several Testcase
+	// The Policy matches the Rego module rule that we are testing; this is the
+	// default for all the Tests in the Testcase, but each Test can override it
+	// (or the Package) with its own Target.
 	Policy string `yaml:"policy"`
 }
 
+// Override returns a copy of this Target, with the Package and/or Policy
+// replaced by those (if any) defined in the `other` Target.
+func (t Target) Override(other *Target) Target {
+	if other == nil {
+		return t
+	}
+	if other.Package != "" {
+		t.Package = other.Package
+	}
+	if other.Policy != "" {
+		t.Policy = other.Policy
+	}
+	return t
+}
+
+// Endpoint is the path (relative to the OPA `/v1/data` API) of the rule defined
+// by this Target: a `com.example.users` Package, with a `grant` Policy,
+// maps to `com/example/users/grant`.
+func (t Target) Endpoint() string {
+	pkg := strings.ReplaceAll(t.Package, ".", "/")
+	return strings.Join([]string{pkg, t.Policy}, "/")
+}
+
 // A Test is a single assertion made against the Rego policies,
 // with an expectation of success or failure (
 // depending on whether we are testing an `allow` or `deny` scenario).
 //
 // Test are grouped in Testcase units and will map one-to-one to OPA server HTTP Request objects,
-// invoked against the Target (policy).
+// invoked against the Target (policy); if the Test defines its own Target, this will
+// override the Testcase's one.
+//
+// Tags are used to select which tests to run, and are added to those of the Testcase.
+//
+// A Test can be temporarily disabled with a Skip reason, or focused upon with Only:
+// if any of the tests to run is marked Only, all the others will be skipped.
 type Test struct {
 	Name     string   `yaml:"name"`
 	Expect   bool     `yaml:"expect"`
+	Target   *Target  `yaml:"target,omitempty"`
+	Tags     []string `yaml:"tags,omitempty"`
+	Skip     string   `yaml:"skip,omitempty"`
+	Only     bool     `yaml:"only,omitempty"`
 	Token    JwtBody  `yaml:"token"`
 	Resource Resource `yaml:"resource"`
+
+	// Line is the position of the Test in the Testcase YAML file
+	Line int `yaml:"-"`
 }
 
 // A Testcase is the central part of the application: it describes a coherent
@@ -92,9 +133,17 @@ type Testcase struct {
 
 	// The "iss" claim for the JWT to be generated; can be overridden in a `Test`
 	// using the `Token.Issuer` field, if needed.
-	Iss    string `yaml:"iss"`
-	Target Target `yaml:"target"`
-	Tests  []Test `yaml:"tests"`
+	Iss    string   `yaml:"iss"`
+	Target Target   `yaml:"target"`
+	Tags   []string `yaml:"tags,omitempty"`
+
+	// Skip and Only apply to all the Tests in the Testcase
+	Skip  string `yaml:"skip,omitempty"`
+	Only  bool   `yaml:"only,omitempty"`
+	Tests []Test `yaml:"tests"`
+
+	// File is the path of the YAML file the Testcase was read from
+	File string `yaml:"-"`
 }
 
 // A TestcaseTemplate is the contents of a YAML (
@@ -109,36 +158,158 @@ type TestcaseTemplate struct {
 // The TestUnit unifies the test subject (the Endpoint),
 // the Body of the test (what we are evaluating against the policy defined for the Endpoint) and
 // the Expectation (whether this is expected to succeed or fail).
+//
+// The Tags are those of both the Test and its Testcase, and are only used to select the
+// TestUnits to run; if Skip is not empty, the TestUnit will not be run, and reported as skipped
+// with Skip as the reason.
+//
+// File and Line are the position of the Test in the Testcase YAML file.
 type TestUnit struct {
 	Name        string
+	Testcase    string
+	File        string
+	Line        int
 	Endpoint    string
 	Body        TestBody
 	Expectation bool
+	Tags        []string
+	Skip        string
+	Only        bool
+}
+
+// Position returns the `file:line` of the Test in its Testcase, if known.
+func (t *TestUnit) Position() string {
+	if t.File == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", t.File, t.Line)
+}
+
+// A BodyTrace is the outcome of the evaluation of one of the bodies of the target rule:
+// if it did not succeed, FailedExpr is the first expression that failed.
+type BodyTrace struct {
+	Location   string
+	Succeeded  bool
+	FailedExpr string `json:",omitempty"`
+	FailedLine int    `json:",omitempty"`
+}
+
+// An Explanation is a condensed trace of the evaluation of a TestUnit, showing why
+// the target rule evaluated to the (unexpected) Result.
+type Explanation struct {
+	Rule   string
+	Result interface{}
+	Bodies []BodyTrace
+}
+
+func (e *Explanation) String() string {
+	var lines = []string{fmt.Sprintf("%s = %v", e.Rule, e.Result)}
+	for _, body := range e.Bodies {
+		if body.Succeeded {
+			lines = append(lines, fmt.Sprintf("  %s: succeeded", body.Location))
+		} else if body.FailedExpr != "" {
+			lines = append(lines, fmt.Sprintf("  %s: failed at line %d: %s",
+				body.Location, body.FailedLine, body.FailedExpr))
+		} else {
+			lines = append(lines, fmt.Sprintf("  %s: not evaluated", body.Location))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// UndefinedResult is the Error of the tests whose target rule is undefined.
+const UndefinedResult = "nothing to show"
+
+// A TestResult is the outcome of running a TestUnit: the Actual value is the `result`
+// returned by OPA (nil if undefined), while Error describes why the test failed,
+// if it did not return a boolean (or OPA could not be reached).
+//
+// Body is the request sent to OPA, and Duration the time it took to evaluate it.
+//
+// When OPA decision logging is enabled, DecisionID identifies the OPA decision, and the
+// failed tests carry their DecisionLog entry.
+type TestResult struct {
+	Name        string
+	Testcase    string
+	File        string `json:",omitempty"`
+	Line        int    `json:",omitempty"`
+	Endpoint    string
+	Passed      bool
+	Expected    bool
+	Actual      interface{}            `json:",omitempty"`
+	Error       string                 `json:",omitempty"`
+	Explanation *Explanation           `json:",omitempty"`
+	Body        *TestBody              `json:",omitempty"`
+	Duration    time.Duration          `json:",omitempty"`
+	DecisionID  string                 `json:",omitempty"`
+	DecisionLog map[string]interface{} `json:",omitempty"`
 }
 
 // TestReport will collect and report all test results, including failures
-// TODO: need a much better reporting
 type TestReport struct {
 	Succeeded uint
 	Failed    uint
+	Skipped   uint
 	Total     uint
 
-	FailedNames []string
+	Results      []*TestResult
+	FailedNames  []string
+	SkippedNames []string `json:",omitempty"`
+	// SkipReasons maps the names of the skipped tests to the reason why they were skipped
+	SkipReasons map[string]string `json:",omitempty"`
+	// BundleSHA256 is the digest of the bundle the tests were run against
+	BundleSHA256 string `json:",omitempty"`
+
+	// Progress (if not nil) is called with each result, as soon as it is reported.
+	Progress func(result *TestResult) `json:"-"`
 
 	mutex sync.Mutex
 }
 
-func (r *TestReport) IncSuccess() {
+func (r *TestReport) ReportSuccess(result *TestResult) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 	r.Total++
 	r.Succeeded++
+	result.Passed = true
+	r.Results = append(r.Results, result)
+	if r.Progress != nil {
+		r.Progress(result)
+	}
 }
 
-func (r *TestReport) ReportFailure(name string) {
+func (r *TestReport) ReportFailure(result *TestResult) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 	r.Total++
 	r.Failed++
-	r.FailedNames = append(r.FailedNames, name)
+	result.Passed = false
+	r.Results = append(r.Results, result)
+	r.FailedNames = append(r.FailedNames, result.Name)
+	if r.Progress != nil {
+		r.Progress(result)
+	}
+}
+
+// Failures returns the results of all the tests which failed.
+func (r *TestReport) Failures() []*TestResult {
+	var failures []*TestResult
+	for _, result := range r.Results {
+		if !result.Passed {
+			failures = append(failures, result)
+		}
+	}
+	return failures
+}
+
+func (r *TestReport) ReportSkipped(name string, reason string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Total++
+	r.Skipped++
+	r.SkippedNames = append(r.SkippedNames, name)
+	if r.SkipReasons == nil {
+		r.SkipReasons = make(map[string]string)
+	}
+	r.SkipReasons[name] = reason
 }
@@ -6,7 +6,6 @@ package testing
 
 import (
 	"encoding/json"
-	"gopkg.in/yaml.v2"
 	"os"
 )
 
@@ -24,15 +23,17 @@ func ReadManifest(path string) *BundleManifest {
 	return &manifest
 }
 
+// ReadTestcase decodes the Testcase in the YAML file at `path`, and validates it:
+// unknown fields, missing required ones and duplicate Test names are all reported
+// as a LintError.
 func ReadTestcase(path string) (*Testcase, error) {
-	yamlTestcase, err := os.Open(path)
+	testcase, problems, err := parseTestcase(path)
 	if err != nil {
 		return nil, err
 	}
-	var template TestcaseTemplate
-	if err := yaml.NewDecoder(yamlTestcase).Decode(&template); err != nil {
-		Log.Error("cannot decode Testcase %s: %s", path, err)
-		return nil, err
+	if len(problems) > 0 {
+		Log.Error("invalid Testcase %s: %d problem(s) found", path, len(problems))
+		return nil, LintError(problems)
 	}
-	return &template.Body, nil
+	return testcase, nil
 }